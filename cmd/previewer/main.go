@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,11 +12,12 @@ import (
 
 	lrucache "github.com/Lanworm/image-previewer/internal/cache"
 	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/Lanworm/image-previewer/internal/http/client"
 	"github.com/Lanworm/image-previewer/internal/http/server"
 	"github.com/Lanworm/image-previewer/internal/http/server/httphandler"
 	"github.com/Lanworm/image-previewer/internal/logger"
 	"github.com/Lanworm/image-previewer/internal/service"
-	"github.com/Lanworm/image-previewer/internal/storage/filestorage"
+	"github.com/Lanworm/image-previewer/internal/signature"
 	"github.com/Lanworm/image-previewer/pkg/shortcuts"
 )
 
@@ -36,19 +38,32 @@ func main() {
 	configs, err := config.NewConfig(configFile)
 	shortcuts.FatalIfErr(err)
 
+	if flag.Arg(0) == "sign" {
+		printSignedPath(configs.Signing, flag.Arg(1), flag.Arg(2))
+		return
+	}
+
 	logg, err := logger.New(configs.Logger.Level, os.Stdout)
 	shortcuts.FatalIfErr(err)
-	storage := filestorage.NewFileStorage(configs.Storage.Path)
-	cache := lrucache.NewCache(configs.Cache.Capacity)
-	err = cache.InitCache(configs.Storage.Path, storage)
-	shortcuts.FatalIfErr(err)
-	imgService := service.NewImageService(logg, storage, cache, configs.Service.Size)
 	ctx, cancel := signal.NotifyContext(context.Background(),
 		syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	defer cancel()
+	storage, err := newStorage(ctx, configs.Storage, logg)
+	shortcuts.FatalIfErr(err)
+	cache := lrucache.NewCache(configs.Cache.Capacity)
+	// Прогрев читает метаданные каждого превью, для S3 это запрос на объект,
+	// поэтому кеш удаленного хранилища заполняется по мере обращений
+	if configs.Storage.Type != config.StorageTypeS3 {
+		if err := cache.InitCache(storage); err != nil {
+			logg.Warning("failed to warm up cache: " + err.Error())
+		}
+	}
+	httpClient, err := client.NewHTTPClient(configs.Client)
+	shortcuts.FatalIfErr(err)
+	imgService := service.NewImageService(logg, storage, cache, httpClient, configs.Service, configs.Origins)
 	httpServer := server.NewHTTPServer(logg, configs.Server.HTTP)
-	handlerHTTP := httphandler.NewHandler(logg, imgService)
-	httpServer.RegisterRoutes(handlerHTTP)
+	handlerHTTP := httphandler.NewHandler(logg, imgService, signature.NewSigner(configs.Signing))
+	httpServer.RegisterRoutes(handlerHTTP, httphandler.NewMetricsHandler(httpClient))
 	go func() {
 		logg.ServerLog(fmt.Sprintf("http server started on: http://%s", configs.Server.HTTP.GetFullAddress()))
 		if err := httpServer.Start(); err != nil {
@@ -67,4 +82,13 @@ func main() {
 	if err := httpServer.Stop(timeOutCtx); err != nil {
 		logg.Error("failed to stop http server: " + err.Error())
 	}
+	// Фоновые обновления превью должны закончить запись до закрытия хранилища
+	imgService.Wait()
+
+	// Закрываем хранилище, если оно держит открытые ресурсы
+	if closer, ok := storage.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logg.Error("failed to close storage: " + err.Error())
+		}
+	}
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/Lanworm/image-previewer/internal/signature"
+)
+
+// printSignedPath выводит подписанный путь превью: previewer sign /fill/300/200/cdn.example.com/a.jpg [24h].
+// Если задан срок, адрес перестает действовать по его истечении. Путь подписывается после
+// очистки, как его увидит сервис: /fill/300/200/https://cdn.example.com/a.jpg станет
+// /fill/300/200/https:/cdn.example.com/a.jpg.
+func printSignedPath(conf config.SigningConf, path, ttl string) {
+	signer := signature.NewSigner(conf)
+	if !signer.Enabled() {
+		fmt.Fprintln(os.Stderr, "signing keys are not configured")
+		os.Exit(1)
+	}
+	if path == "" || path[0] != '/' {
+		fmt.Fprintln(os.Stderr, "usage: previewer sign /fill/{width}/{height}/{url} [ttl]")
+		os.Exit(1)
+	}
+
+	path = signature.CleanPath(path)
+	if ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid ttl %q\n", ttl)
+			os.Exit(1)
+		}
+		path = signature.ExpiringPath(path, time.Now().Add(d))
+	}
+
+	fmt.Println("/" + signer.Sign(path) + path)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/Lanworm/image-previewer/internal/logger"
+	"github.com/Lanworm/image-previewer/internal/storage"
+	"github.com/Lanworm/image-previewer/internal/storage/boltstorage"
+	"github.com/Lanworm/image-previewer/internal/storage/filestorage"
+	"github.com/Lanworm/image-previewer/internal/storage/memorystorage"
+	"github.com/Lanworm/image-previewer/internal/storage/s3storage"
+)
+
+// newStorage создает хранилище превью в соответствии с типом из конфигурации.
+func newStorage(ctx context.Context, conf config.StorageConf, logg *logger.Logger) (storage.Storage, error) {
+	switch conf.Type {
+	case config.StorageTypeS3:
+		return s3storage.NewS3Storage(conf.S3)
+	case config.StorageTypeMemory:
+		return memorystorage.NewMemoryStorage(), nil
+	case config.StorageTypeBolt:
+		return boltstorage.NewBoltStorage(conf.Bolt.Path)
+	}
+
+	fileStorage := filestorage.NewFileStorage(conf.Path)
+	fileStorage.SetQuota(filestorage.Quota{
+		MaxSize:  conf.MaxSize * 1024 * 1024,
+		MaxFiles: conf.MaxFiles,
+	})
+	if err := fileStorage.Scan(); err != nil {
+		return nil, err
+	}
+	go fileStorage.StartGC(ctx, conf.GCInterval, logg)
+
+	return fileStorage, nil
+}
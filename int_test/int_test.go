@@ -2,6 +2,7 @@ package int_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Lanworm/image-previewer/internal/config"
 	"github.com/Lanworm/image-previewer/internal/http/client"
 	"github.com/Lanworm/image-previewer/internal/http/server/dto"
 	"github.com/Lanworm/image-previewer/internal/service"
@@ -71,7 +73,7 @@ func TestRemoteServerDoesNotExist(t *testing.T) {
 	imagePath := "image1.jpg"
 
 	// Запрашиваем картинку с сервера, который не существует
-	resp, err := GetImage(imagePath, "300", "400", "http://NotExistHost")
+	resp, err := GetImage(imagePath, "300", "400", "NotExistHost")
 
 	// Проверяем, что ошибка не равна nil
 	assert.NotNil(t, err, "expected an error but got nil")
@@ -193,25 +195,31 @@ func GetImage(imgPath string, imgH string, imgW string, hostPath string) (*http.
 		appURL = "localhost"
 	}
 
-	// Подменяем URL сервиса в если это необходимо для теста.
+	// Подменяем хост сервиса, если это необходимо для теста (без схемы, она задается ниже).
 	if hostPath != "" {
 		nginxURL = hostPath
 	}
 
-	// Формируем базовый URL для запроса.
-	baseURL := fmt.Sprintf("http://%s:8090/fill/%s/%s/%s:3080/images/%s", appURL, imgH, imgW, nginxURL, imgPath)
+	// Формируем базовый URL для запроса, nginx отдает изображения по http, а схема по умолчанию - https.
+	baseURL := fmt.Sprintf("http://%s:8090/fill/%s/%s/http:/%s:3080/images/%s", appURL, imgH, imgW, nginxURL, imgPath)
 
 	// Создаем HTTP-клиент с таймаутом в 10 секунд.
-	HTTPClient := client.NewHTTPClient(10 * time.Second)
+	HTTPClient, err := client.NewHTTPClient(config.ClientConf{
+		Timeout:    10 * time.Second,
+		AllowCIDRs: []string{"0.0.0.0/0", "::/0"},
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	// Выполняем GET-запрос по сформированному URL.
-	resp, err := HTTPClient.DoRequest("GET", baseURL, nil, nil)
+	resp, err := HTTPClient.DoRequest(context.Background(), "GET", baseURL, nil, nil)
 	if err != nil {
 		return nil, err // Возвращаем ошибку, если запрос не удался.
 	}
 
-	// Проверяем, не вернулся ли статус 500 (Internal Server Error).
-	if resp.StatusCode == http.StatusInternalServerError {
+	// Проверяем, не вернулся ли код ошибки (например, 500 или 502 при ошибке удаленного сервера).
+	if resp.StatusCode != http.StatusOK {
 		var res dto.Result
 		decoder := json.NewDecoder(resp.Body) // Создаем декодер для чтения JSON из тела ответа.
 		defer resp.Body.Close()               // Закрываем тело запроса после использования.
@@ -1,24 +1,33 @@
 package lrucache
 
 import (
+	"errors"
 	"fmt"
-	"image"
+	"io"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/Lanworm/image-previewer/internal/storage"
 )
 
 type Key string
 
+// Item закодированное превью вместе с его метаданными.
+type Item struct {
+	Data []byte
+	Meta storage.Metadata
+}
+
 type Cache interface {
-	Set(key Key, value image.Image) bool
-	Get(key Key) (image.Image, bool)
+	Set(key Key, value Item) bool
+	Get(key Key) (Item, bool)
 	Clear()
-	InitCache(path string, storage storage.Storage) error
+	InitCache(storage storage.Storage) error
 }
 
 type CacheListItem struct {
-	value image.Image
+	value Item
 	key   Key
 }
 
@@ -37,7 +46,7 @@ func NewCache(capacity int) Cache {
 	}
 }
 
-func (c *lruCache) Set(key Key, value image.Image) bool {
+func (c *lruCache) Set(key Key, value Item) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	cacheListItem, ok := c.items[key]
@@ -68,7 +77,7 @@ func (c *lruCache) Set(key Key, value image.Image) bool {
 	return false
 }
 
-func (c *lruCache) Get(key Key) (image.Image, bool) {
+func (c *lruCache) Get(key Key) (Item, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	cacheItem, ok := c.items[key]
@@ -80,7 +89,7 @@ func (c *lruCache) Get(key Key) (image.Image, bool) {
 		return cad.value, true
 	}
 
-	return nil, false
+	return Item{}, false
 }
 
 func (c *lruCache) Clear() {
@@ -90,21 +99,57 @@ func (c *lruCache) Clear() {
 	c.items = make(map[Key]*ListItem, c.capacity)
 }
 
-func (c *lruCache) InitCache(folderPath string, storage storage.Storage) error {
-	fileNames, err := storage.GetFileList(folderPath)
+// InitCache заполняет кеш самыми востребованными превью из хранилища. Превью, которые
+// не удалось прочитать, пропускаются, их ошибки возвращаются вместе после заполнения кеша.
+func (c *lruCache) InitCache(storage storage.Storage) error {
+	fileNames, err := storage.List()
 	if err != nil {
 		return err
 	}
 
+	// Восстанавливаем порядок вытеснения по времени последнего обращения из метаданных,
+	// чтобы в кеш попали самые востребованные изображения
+	lastAccess := make(map[string]time.Time, len(fileNames))
+	for _, fileName := range fileNames {
+		meta, err := storage.GetMetadata(fileName)
+		if err == nil {
+			lastAccess[fileName] = meta.LastAccessAt
+		}
+	}
+	sort.SliceStable(fileNames, func(i, j int) bool {
+		return lastAccess[fileNames[i]].Before(lastAccess[fileNames[j]])
+	})
+	if len(fileNames) > c.capacity {
+		fileNames = fileNames[len(fileNames)-c.capacity:]
+	}
+
+	var errs []error
 	for _, fileName := range fileNames {
-		imgFile, err := storage.Get(fileName)
+		item, err := loadItem(storage, fileName)
 		if err != nil {
-			return err
+			errs = append(errs, fmt.Errorf("load %s: %w", fileName, err))
+			continue
 		}
 
-		c.Set(Key(fileName), imgFile)
+		c.Set(Key(fileName), item)
 		fmt.Printf("added to the cache: %s\n", fileName)
 	}
 
-	return nil
+	return errors.Join(errs...)
+}
+
+// loadItem читает превью из хранилища без декодирования изображения.
+func loadItem(storage storage.Storage, id string) (Item, error) {
+	reader, meta, err := storage.Get(id)
+	if err != nil {
+		return Item{}, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return Item{}, err
+	}
+
+	return Item{Data: data, Meta: meta}, nil
 }
@@ -1,10 +1,15 @@
 package lrucache
 
 import (
+	"errors"
 	"image"
+	"image/jpeg"
+	"io"
 	"testing"
 
+	storagepkg "github.com/Lanworm/image-previewer/internal/storage"
 	"github.com/Lanworm/image-previewer/internal/storage/filestorage"
+	"github.com/Lanworm/image-previewer/internal/storage/memorystorage"
 	"github.com/stretchr/testify/require"
 )
 
@@ -12,7 +17,7 @@ func TestLRUCache(t *testing.T) {
 	cache := NewCache(2)
 
 	// Проверка добавления и получения изображения из кеша
-	img1 := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	img1 := Item{Data: []byte("image1")}
 	cache.Set(Key("image1"), img1)
 
 	retrievedImg1, found1 := cache.Get(Key("image1"))
@@ -20,10 +25,10 @@ func TestLRUCache(t *testing.T) {
 	require.Equal(t, img1, retrievedImg1, "Изображение 'image1' не соответствует ожидаемому")
 
 	// Проверка замещения изображения в кеше
-	img2 := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	img2 := Item{Data: []byte("image2")}
 	cache.Set(Key("image2"), img2)
 
-	img3 := image.NewRGBA(image.Rect(0, 0, 300, 300))
+	img3 := Item{Data: []byte("image3")}
 	cache.Set(Key("image3"), img3)
 
 	_, found2 := cache.Get(Key("image1"))
@@ -41,18 +46,21 @@ func TestInitCache(t *testing.T) {
 	testCache := NewCache(capacity)
 
 	// Создание временного файла с изображением для теста
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
-	err := storage.Set(img, "temp_image.jpg")
+	w, err := storage.Set("temp_image.jpg", storagepkg.Metadata{ContentType: "image/jpeg"})
 	if err != nil {
 		return
 	}
-	err = testCache.InitCache("../../test_images", storage)
+	err = jpeg.Encode(w, image.NewRGBA(image.Rect(0, 0, 100, 100)), nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	err = testCache.InitCache(storage)
 	require.NoError(t, err, "Ошибка при инициализации кеша изображений")
 
 	// Проверка добавления изображения в кеш
 	retrievedImg, found := testCache.Get(Key("temp_image.jpg"))
 	require.True(t, found, "Изображение 'temp_image.jpg' не найдено в кеше")
-	require.NotNil(t, retrievedImg, "Изображение 'temp_image.jpg' не было добавлено в кеш")
+	require.NotEmpty(t, retrievedImg.Data, "Изображение 'temp_image.jpg' не было добавлено в кеш")
+	require.Equal(t, "image/jpeg", retrievedImg.Meta.ContentType)
 
 	// Удаление временного файла после теста
 	err = storage.Delete("temp_image.jpg")
@@ -61,3 +69,37 @@ func TestInitCache(t *testing.T) {
 		return
 	}
 }
+
+// brokenStorage хранилище, из которого не читается превью broken.
+type brokenStorage struct {
+	storagepkg.Storage
+}
+
+func (s brokenStorage) Get(id string) (io.ReadCloser, storagepkg.Metadata, error) {
+	if id == "broken" {
+		return nil, storagepkg.Metadata{}, errors.New("read failed")
+	}
+	return s.Storage.Get(id)
+}
+
+// Непрочитанное превью не мешает заполнить кеш остальными.
+func TestInitCacheSkipsFailedItems(t *testing.T) {
+	storage := memorystorage.NewMemoryStorage()
+	for _, id := range []string{"broken", "ok"} {
+		w, err := storage.Set(id, storagepkg.Metadata{ContentType: "image/jpeg"})
+		require.NoError(t, err)
+		_, err = w.Write([]byte(id))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+
+	testCache := NewCache(2)
+	err := testCache.InitCache(brokenStorage{storage})
+	require.ErrorContains(t, err, "read failed")
+
+	item, found := testCache.Get(Key("ok"))
+	require.True(t, found)
+	require.Equal(t, "ok", string(item.Data))
+	_, found = testCache.Get(Key("broken"))
+	require.False(t, found)
+}
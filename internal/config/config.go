@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -12,12 +13,22 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	StorageTypeFile   = "file"
+	StorageTypeS3     = "s3"
+	StorageTypeMemory = "memory"
+	StorageTypeBolt   = "bolt"
+)
+
 type Config struct {
 	Logger  LoggerConf
 	Server  ServerConf
 	Cache   CacheConf
 	Storage StorageConf
 	Service ServiceConf
+	Client  ClientConf
+	Origins OriginsConf
+	Signing SigningConf
 }
 
 type ServerConf struct {
@@ -44,10 +55,141 @@ type CacheConf struct {
 	Capacity int `validate:"required,gt=1,lte=99"`
 }
 type StorageConf struct {
-	Path string `validate:"required,dirpath"`
+	Type       string        `validate:"omitempty,oneof=file s3 memory bolt"`
+	Path       string        `validate:"omitempty,dirpath"`
+	MaxSize    int64         `yaml:"max_size" validate:"gte=0"`
+	MaxFiles   int           `yaml:"max_files" validate:"gte=0"`
+	GCInterval time.Duration `yaml:"gc_interval" validate:"gte=0"`
+	S3         S3Conf
+	Bolt       BoltConf
+}
+type BoltConf struct {
+	Path string `validate:"omitempty,filepath"`
+}
+type S3Conf struct {
+	Endpoint  string `validate:"omitempty,url"`
+	Region    string
+	Bucket    string
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	Prefix    string
+	PathStyle bool `yaml:"path_style"`
+	Timeout   time.Duration
 }
 type ServiceConf struct {
-	Size int `validate:"required"`
+	Size          int     `validate:"required"`
+	Quality       int     `validate:"omitempty,gte=1,lte=100"`
+	MaxMegapixels float64 `yaml:"max_megapixels" validate:"gte=0"`
+	// Количество одновременных изменений размера, по умолчанию по числу процессоров.
+	MaxConcurrentResizes int `yaml:"max_concurrent_resizes" validate:"gte=0"`
+	// TTL время, в течение которого превью отдается без проверки у источника, 0 - без проверки.
+	TTL time.Duration `validate:"gte=0"`
+	// Время после истечения TTL, в течение которого превью отдается сразу и обновляется в фоне.
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate" validate:"gte=0"`
+	// Время после истечения TTL, в течение которого превью отдается, если источник недоступен.
+	StaleIfError time.Duration `yaml:"stale_if_error" validate:"gte=0"`
+}
+
+type ClientConf struct {
+	Timeout             time.Duration `validate:"gte=0"`
+	MaxIdleConns        int           `yaml:"max_idle_conns" validate:"gte=0"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host" validate:"gte=0"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host" validate:"gte=0"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout" validate:"gte=0"`
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout" validate:"gte=0"`
+	HTTP2               bool          `yaml:"http2"`
+	UserAgent           string        `yaml:"user_agent"`
+	MaxRedirects        int           `yaml:"max_redirects" validate:"gte=0"`
+	AllowCIDRs          []string      `yaml:"allow_cidrs" validate:"dive,cidr"`
+	DenyCIDRs           []string      `yaml:"deny_cidrs" validate:"dive,cidr"`
+	Breaker             BreakerConf
+	Proxy               ProxyConf
+}
+
+// ProxyConf настройки прокси для запросов к источникам.
+type ProxyConf struct {
+	// Адрес прокси вида http://, https:// или socks5://host:port.
+	URL      string `validate:"omitempty,url"`
+	Username string
+	Password string
+	// Хосты, домены (.example.com) и сети, запросы к которым выполняются без прокси.
+	NoProxy []string `yaml:"no_proxy"`
+	// Не использовать HTTP_PROXY, HTTPS_PROXY и NO_PROXY, если адрес прокси не задан.
+	// По умолчанию они учитываются, как в стандартном http.Transport.
+	IgnoreEnv bool `yaml:"ignore_env"`
+}
+
+// BreakerConf настройки предохранителя, отключающего запросы к недоступному источнику.
+type BreakerConf struct {
+	// Количество неудачных запросов подряд, после которого хост отключается, 0 - без предохранителя.
+	FailureThreshold int `yaml:"failure_threshold" validate:"gte=0"`
+	// Время, на которое хост отключается перед пробным запросом.
+	OpenTimeout time.Duration `yaml:"open_timeout" validate:"gte=0"`
+}
+
+// SigningConf настройки подписи адресов превью.
+type SigningConf struct {
+	// Ключи HMAC: адреса подписываются первым ключом, а проверка проходит с любым из них,
+	// что позволяет заменять ключи постепенно. Пустой список - подпись не требуется.
+	Keys []string `validate:"dive,min=16"`
+	// Допустимое расхождение часов при проверке срока действия адреса.
+	ClockSkew time.Duration `yaml:"clock_skew" validate:"gte=0"`
+}
+
+type OriginsConf struct {
+	// Схема для адресов источников, заданных без схемы, по умолчанию https.
+	DefaultScheme string `yaml:"default_scheme" validate:"omitempty,oneof=http https"`
+	// Разрешенные хосты источников: точное имя или шаблон вида *.example.com.
+	// Пустой список разрешает любые хосты.
+	AllowedHosts []string `yaml:"allowed_hosts"`
+	// Заголовки запроса клиента, которые передаются источнику, остальные отбрасываются.
+	ForwardHeaders []string `yaml:"forward_headers"`
+	// Заголовки, добавляемые ко всем запросам к источникам.
+	Headers map[string]string
+	// Повтор запросов к источникам по умолчанию, профиль может задать собственный.
+	Retry    RetryConf
+	Profiles []OriginProfile `validate:"dive"`
+	// Псевдонимы источников: адрес @alias/путь заменяется на базовый адрес и путь.
+	Aliases map[string]string `validate:"dive,keys,required,excludesall=/,endkeys,url"`
+	// Локальные директории, изображения из которых доступны по адресу local/{alias}/путь.
+	Local []LocalRoot `validate:"dive"`
+}
+
+// LocalRoot локальная директория-источник изображений.
+type LocalRoot struct {
+	Alias string `validate:"required,excludesall=/"`
+	Path  string `validate:"required"`
+}
+
+// OriginProfile настройки загрузки изображений для хостов, подходящих под шаблон.
+type OriginProfile struct {
+	Host         string `validate:"required"`
+	Headers      map[string]string
+	Timeout      time.Duration `validate:"gte=0"`
+	MaxSize      int           `yaml:"max_size" validate:"gte=0"`
+	RequireHTTPS bool          `yaml:"require_https"`
+	Retry        RetryConf
+	RateLimit    RateLimitConf `yaml:"rate_limit"`
+}
+
+// RateLimitConf ограничение частоты запросов к хосту источника.
+type RateLimitConf struct {
+	// Количество запросов в секунду, 0 - без ограничения.
+	Rate float64 `validate:"gte=0"`
+	// Количество запросов, которое можно выполнить подряд без ожидания.
+	Burst int `validate:"gte=0"`
+	// Максимальное время ожидания очереди, после которого запрос завершается ошибкой.
+	MaxWait time.Duration `yaml:"max_wait" validate:"gte=0"`
+}
+
+// RetryConf политика повтора запросов при временных ошибках источника.
+type RetryConf struct {
+	// Максимальное количество попыток с учетом первой, 0 или 1 - без повторов.
+	MaxAttempts int           `yaml:"max_attempts" validate:"gte=0"`
+	BaseDelay   time.Duration `yaml:"base_delay" validate:"gte=0"`
+	MaxDelay    time.Duration `yaml:"max_delay" validate:"gte=0"`
+	// Общее время на все попытки, включая загрузку итогового ответа.
+	Deadline time.Duration `validate:"gte=0"`
 }
 
 func NewConfig(configFile string) (*Config, error) {
@@ -67,5 +209,15 @@ func NewConfig(configFile string) (*Config, error) {
 		return nil, err
 	}
 
+	if (c.Storage.Type == "" || c.Storage.Type == StorageTypeFile) && c.Storage.Path == "" {
+		return nil, errors.New("file storage requires path")
+	}
+	if c.Storage.Type == StorageTypeS3 && (c.Storage.S3.Endpoint == "" || c.Storage.S3.Bucket == "") {
+		return nil, errors.New("s3 storage requires endpoint and bucket")
+	}
+	if c.Storage.Type == StorageTypeBolt && c.Storage.Bolt.Path == "" {
+		return nil, errors.New("bolt storage requires database path")
+	}
+
 	return c, nil
 }
@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("origin is unavailable, circuit breaker is open")
+
+const (
+	defaultBreakerOpenTimeout = 30 * time.Second
+	// breakerIdleTimeout через сколько после последнего результата забывается предохранитель хоста.
+	breakerIdleTimeout = 10 * time.Minute
+	// maxBreakerHosts ограничивает количество хостов с предохранителями.
+	maxBreakerHosts = 10000
+)
+
+// BreakerState состояние предохранителя источника.
+type BreakerState int
+
+const (
+	// BreakerClosed запросы выполняются, неудачи подряд подсчитываются.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen запросы отклоняются без обращения к источнику.
+	BreakerOpen
+	// BreakerHalfOpen пропускается один пробный запрос для проверки восстановления.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerStat состояние предохранителя одного хоста для метрик.
+type BreakerStat struct {
+	Host  string
+	State BreakerState
+	// Opens сколько раз предохранитель размыкался.
+	Opens uint64
+}
+
+// breakerOutcome результат запроса с точки зрения предохранителя.
+type breakerOutcome int
+
+const (
+	outcomeSuccess breakerOutcome = iota
+	outcomeFailure
+	// outcomeIgnored запрос не говорит о доступности источника, например отменен клиентом.
+	outcomeIgnored
+)
+
+type breaker struct {
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	opens     uint64
+	updatedAt time.Time
+}
+
+// breakers предохранители для каждого хоста источника. После threshold неудач подряд
+// запросы к хосту отклоняются с ErrCircuitOpen, пока не истечет openTimeout.
+// Хранятся только предохранители хостов с недавними неудачами.
+type breakers struct {
+	threshold   int
+	openTimeout time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	hosts   map[string]*breaker
+	sweptAt time.Time
+}
+
+func newBreakers(threshold int, openTimeout time.Duration) *breakers {
+	if openTimeout <= 0 {
+		openTimeout = defaultBreakerOpenTimeout
+	}
+
+	return &breakers{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		now:         time.Now,
+		hosts:       make(map[string]*breaker),
+	}
+}
+
+// enabled сообщает, включены ли предохранители.
+func (b *breakers) enabled() bool {
+	return b.threshold > 0
+}
+
+// allow проверяет, можно ли выполнить запрос к хосту.
+func (b *breakers) allow(host string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.hosts[host]
+	if !ok {
+		return nil
+	}
+
+	switch br.state {
+	case BreakerOpen:
+		if b.now().Sub(br.openedAt) < b.openTimeout {
+			return fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+		}
+		br.state = BreakerHalfOpen
+		br.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if br.probing {
+			return fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+		}
+		br.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// report учитывает результат запроса к хосту.
+func (b *breakers) report(host string, outcome breakerOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.sweep(now)
+
+	br, ok := b.hosts[host]
+	if !ok {
+		// При переполнении новые хосты не отслеживаются, пока старые не забудутся
+		if outcome != outcomeFailure || len(b.hosts) >= maxBreakerHosts {
+			return
+		}
+		br = &breaker{}
+		b.hosts[host] = br
+	}
+	br.updatedAt = now
+
+	switch outcome {
+	case outcomeSuccess:
+		if br.state == BreakerClosed {
+			// Хост доступен, его предохранитель не отличается от нового
+			delete(b.hosts, host)
+			return
+		}
+		br.state = BreakerClosed
+		br.failures = 0
+		br.probing = false
+	case outcomeFailure:
+		br.failures++
+		if br.state == BreakerHalfOpen || br.failures >= b.threshold {
+			br.state = BreakerOpen
+			br.openedAt = now
+			br.probing = false
+			br.opens++
+		}
+	case outcomeIgnored:
+		// Пробный запрос не состоялся, следующий запрос станет новой пробой
+		br.probing = false
+	}
+}
+
+// sweep не чаще раза в breakerIdleTimeout удаляет предохранители хостов, к которым давно
+// не было запросов. Разомкнутый предохранитель хранится, пока не истечет openTimeout.
+func (b *breakers) sweep(now time.Time) {
+	if now.Sub(b.sweptAt) < breakerIdleTimeout {
+		return
+	}
+	b.sweptAt = now
+
+	for host, br := range b.hosts {
+		if br.state == BreakerOpen && now.Sub(br.openedAt) < b.openTimeout {
+			continue
+		}
+		if now.Sub(br.updatedAt) >= breakerIdleTimeout {
+			delete(b.hosts, host)
+		}
+	}
+}
+
+// stats возвращает состояние предохранителей, отсортированное по хосту.
+func (b *breakers) stats() []BreakerStat {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := make([]BreakerStat, 0, len(b.hosts))
+	for host, br := range b.hosts {
+		stats = append(stats, BreakerStat{Host: host, State: br.state, Opens: br.opens})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Host < stats[j].Host
+	})
+	return stats
+}
+
+// breakerResult определяет, говорит ли ответ или ошибка о недоступности источника.
+// Ошибки сервера 5xx и сетевые ошибки считаются неудачей, ответы 4xx - успехом,
+// а прочие ошибки, например отказ в переходе по редиректу, не учитываются.
+func breakerResult(ctx context.Context, resp *http.Response, err error) breakerOutcome {
+	// Истекший таймаут профиля говорит о зависшем источнике, а отмена клиентом - нет
+	if errors.Is(ctx.Err(), context.Canceled) || errors.Is(err, ErrForbiddenAddress) {
+		return outcomeIgnored
+	}
+	if err != nil {
+		if isNetworkError(err) {
+			return outcomeFailure
+		}
+		return outcomeIgnored
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
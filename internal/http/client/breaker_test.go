@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakerStates(t *testing.T) {
+	now := time.Now()
+	b := newBreakers(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	// Неудачи не подряд не размыкают предохранитель
+	b.report("a", outcomeFailure)
+	b.report("a", outcomeSuccess)
+	b.report("a", outcomeFailure)
+	require.NoError(t, b.allow("a"))
+
+	b.report("a", outcomeFailure)
+	require.ErrorIs(t, b.allow("a"), ErrCircuitOpen)
+	require.NoError(t, b.allow("b"), "other hosts are not affected")
+
+	// По истечении таймаута пропускается один пробный запрос
+	now = now.Add(time.Minute)
+	require.NoError(t, b.allow("a"))
+	require.ErrorIs(t, b.allow("a"), ErrCircuitOpen)
+	require.Equal(t, []BreakerStat{{Host: "a", State: BreakerHalfOpen, Opens: 1}}, b.stats())
+
+	// Неудачная проба снова размыкает предохранитель
+	b.report("a", outcomeFailure)
+	require.ErrorIs(t, b.allow("a"), ErrCircuitOpen)
+
+	// Отмененная проба не меняет состояние, следующий запрос становится новой пробой
+	now = now.Add(time.Minute)
+	require.NoError(t, b.allow("a"))
+	b.report("a", outcomeIgnored)
+	require.NoError(t, b.allow("a"))
+
+	// Успешная проба замыкает предохранитель
+	b.report("a", outcomeSuccess)
+	require.NoError(t, b.allow("a"))
+	require.NoError(t, b.allow("a"))
+	require.Equal(t, []BreakerStat{{Host: "a", State: BreakerClosed, Opens: 2}}, b.stats())
+}
+
+// Предохранители доступных и давно не запрашиваемых хостов не хранятся.
+func TestBreakersForgetHosts(t *testing.T) {
+	now := time.Now()
+	b := newBreakers(3, time.Hour)
+	b.now = func() time.Time { return now }
+
+	// Успех после неудачи не оставляет записи о хосте
+	b.report("a", outcomeFailure)
+	b.report("a", outcomeSuccess)
+	require.Empty(t, b.stats())
+
+	b.report("idle", outcomeFailure)
+	for i := 0; i < 3; i++ {
+		b.report("open", outcomeFailure)
+	}
+
+	// Разомкнутый предохранитель хранится до истечения openTimeout
+	now = now.Add(breakerIdleTimeout)
+	b.report("b", outcomeFailure)
+	require.Equal(t, []BreakerStat{
+		{Host: "b", State: BreakerClosed},
+		{Host: "open", State: BreakerOpen, Opens: 1},
+	}, b.stats())
+
+	// Количество хранимых предохранителей ограничено
+	for i := len(b.hosts); i < maxBreakerHosts; i++ {
+		b.report(strconv.Itoa(i), outcomeFailure)
+	}
+	b.report("c", outcomeFailure)
+	require.Len(t, b.hosts, maxBreakerHosts)
+	require.NotContains(t, b.hosts, "c")
+}
+
+// После серии ошибок запросы к источнику завершаются сразу, не доходя до сервера.
+func TestClientBreakerFailsFast(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c, err := NewHTTPClient(config.ClientConf{
+		AllowCIDRs: []string{"127.0.0.0/8"},
+		Breaker:    config.BreakerConf{FailureThreshold: 3},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	_, err = c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	stats := c.BreakerStats()
+	require.Len(t, stats, 1)
+	require.Equal(t, BreakerOpen, stats[0].State)
+}
@@ -2,29 +2,208 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+)
+
+const (
+	defaultTimeout             = 10 * time.Second
+	defaultDialTimeout         = 5 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultMaxRedirects        = 10
+	defaultUserAgent           = "image-previewer"
 )
 
+var ErrTooManyRedirects = errors.New("too many redirects")
+
 // Client структура.
 type Client struct {
-	client *http.Client
+	client    *http.Client
+	userAgent string
+	breakers  *breakers
+	limiters  *limiters
 }
 
 // NewHTTPClient функция для создания нового HTTP клиента.
-func NewHTTPClient(timeout time.Duration) *Client {
+// Клиент переиспользует соединения, поэтому его следует создавать один раз.
+func NewHTTPClient(conf config.ClientConf) (*Client, error) {
+	guard, err := newIPGuard(conf.AllowCIDRs, conf.DenyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	idleConnTimeout := conf.IdleConnTimeout
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+	tlsHandshakeTimeout := conf.TLSHandshakeTimeout
+	if tlsHandshakeTimeout <= 0 {
+		tlsHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   defaultDialTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   guard.control,
+	}
+
+	proxy, err := newProxySelector(conf.Proxy, guard)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        conf.MaxIdleConns,
+		MaxIdleConnsPerHost: conf.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     conf.MaxConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
+		ForceAttemptHTTP2:   conf.HTTP2,
+	}
+	if proxy != nil {
+		transport.Proxy = proxy.proxy
+		transport.DialContext = proxy.dialContext(dialer, &net.Dialer{
+			Timeout:   defaultDialTimeout,
+			KeepAlive: 30 * time.Second,
+		})
+	}
+	if !conf.HTTP2 {
+		// Непустая карта отключает автоматическое согласование HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	maxRedirects := conf.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	userAgent := conf.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+
 	return &Client{
+		userAgent: userAgent,
+		breakers:  newBreakers(conf.Breaker.FailureThreshold, conf.Breaker.OpenTimeout),
+		limiters:  newLimiters(),
 		client: &http.Client{
-			Timeout: timeout,
+			Timeout:       timeout,
+			Transport:     transport,
+			CheckRedirect: redirectPolicy(maxRedirects),
 		},
+	}, nil
+}
+
+type redirectCheckKey struct{}
+
+// redirectPolicy разрешает не больше maxRedirects переходов и только по HTTP(S), адрес
+// назначения каждого перехода дополнительно проверяется при подключении. Проверка,
+// переданная с запросом через WithRedirectCheck, применяется к каждому переходу.
+func redirectPolicy(maxRedirects int) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("%w: redirect to %s", ErrForbiddenAddress, req.URL.Redacted())
+		}
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("%w: stopped after %d redirects", ErrTooManyRedirects, maxRedirects)
+		}
+		if check, ok := req.Context().Value(redirectCheckKey{}).(func(*url.URL) error); ok {
+			return check(req.URL)
+		}
+		return nil
+	}
+}
+
+// RedirectChain возвращает адреса всех запросов, выполненных для получения ответа,
+// начиная с исходного. Для ответа без переходов цепочка состоит из одного адреса.
+func RedirectChain(resp *http.Response) []string {
+	var chain []string
+	for req := resp.Request; req != nil; {
+		chain = append(chain, req.URL.Redacted())
+		if req.Response == nil {
+			break
+		}
+		req = req.Response.Request
+	}
+
+	// Цепочка собрана от последнего запроса к первому
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// RequestOption настройка отдельного запроса, например политика, заданная для источника.
+type RequestOption func(o *requestOptions)
+
+type requestOptions struct {
+	retry         config.RetryConf
+	rateLimit     config.RateLimitConf
+	redirectCheck func(*url.URL) error
+}
+
+// WithRedirectCheck задает проверку адреса каждого перехода при редиректах,
+// ошибка проверки прерывает запрос.
+func WithRedirectCheck(check func(*url.URL) error) RequestOption {
+	return func(o *requestOptions) {
+		o.redirectCheck = check
 	}
 }
 
 // DoRequest выполняет HTTP запрос с заданным методом, URL, телом запроса и заголовками.
-func (c *Client) DoRequest(method string, url string, body io.Reader, headers http.Header) (*http.Response, error) {
+// Отмена контекста прерывает как установку соединения, так и чтение тела ответа.
+// Идемпотентные запросы без тела повторяются согласно переданной политике WithRetry.
+func (c *Client) DoRequest(
+	ctx context.Context,
+	method string,
+	rawURL string,
+	body io.Reader,
+	headers http.Header,
+	opts ...RequestOption,
+) (*http.Response, error) {
+	options := requestOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if options.redirectCheck != nil {
+		ctx = context.WithValue(ctx, redirectCheckKey{}, options.redirectCheck)
+	}
+
+	attempt := func(ctx context.Context) (*http.Response, error) {
+		return c.do(ctx, method, rawURL, body, headers, options.rateLimit)
+	}
+	if options.retry.MaxAttempts <= 1 || body != nil || (method != http.MethodGet && method != http.MethodHead) {
+		return attempt(ctx)
+	}
+
+	return newRetryer(options.retry).do(ctx, attempt)
+}
+
+func (c *Client) do(
+	ctx context.Context,
+	method string,
+	rawURL string,
+	body io.Reader,
+	headers http.Header,
+	rateLimit config.RateLimitConf,
+) (*http.Response, error) {
 	// Создаем новый HTTP запрос с заданным методом, URL и телом запроса
-	req, err := http.NewRequestWithContext(context.Background(), method, url, body)
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
 	if err != nil {
 		return nil, err
 	}
@@ -35,12 +214,52 @@ func (c *Client) DoRequest(method string, url string, body io.Reader, headers ht
 			req.Header.Add(key, value)
 		}
 	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	// Ждем своей очереди, если частота запросов к хосту ограничена
+	host := req.URL.Host
+	if err := c.limiters.wait(ctx, host, rateLimit); err != nil {
+		return nil, err
+	}
+
+	if !c.breakers.enabled() {
+		return c.client.Do(req)
+	}
+
+	// Недоступный источник отклоняется сразу, не дожидаясь таймаута
+	if err := c.breakers.allow(host); err != nil {
+		return nil, err
+	}
 
 	// Выполняем HTTP запрос с помощью клиента
 	resp, err := c.client.Do(req)
+	c.breakers.report(host, breakerResult(ctx, resp, err))
 	if err != nil {
 		return nil, err
 	}
 
 	return resp, nil
 }
+
+// CancelOnClose возвращает тело ответа, которое при закрытии отменяет контекст запроса.
+// Контекст с таймаутом нельзя отменять сразу после получения ответа: тело читается позже.
+func CancelOnClose(body io.ReadCloser, cancel context.CancelFunc) io.ReadCloser {
+	return &cancelBody{ReadCloser: body, cancel: cancel}
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// BreakerStats возвращает состояние предохранителей хостов, к которым недавно были неудачные запросы.
+func (c *Client) BreakerStats() []BreakerStat {
+	return c.breakers.stats()
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+// Повторные запросы к одному хосту используют уже открытое соединение.
+func TestClientReusesConnections(t *testing.T) {
+	var connections int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c, err := NewHTTPClient(config.ClientConf{MaxIdleConnsPerHost: 2, AllowCIDRs: []string{"127.0.0.0/8"}})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		resp, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil)
+		require.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&connections))
+}
+
+func TestClientRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/first", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/second", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/second", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/final", http.StatusFound)
+	})
+	mux.HandleFunc("/final", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c, err := NewHTTPClient(config.ClientConf{MaxRedirects: 3, AllowCIDRs: []string{"127.0.0.0/8"}})
+	require.NoError(t, err)
+
+	// Цепочка переходов ограничена
+	_, err = c.DoRequest(context.Background(), http.MethodGet, srv.URL+"/loop", nil, nil)
+	require.ErrorIs(t, err, ErrTooManyRedirects)
+
+	resp, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL+"/first", nil, nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, []string{srv.URL + "/first", srv.URL + "/second", srv.URL + "/final"}, RedirectChain(resp))
+
+	// Дополнительная проверка применяется к каждому переходу
+	errDenied := errors.New("denied")
+	_, err = c.DoRequest(context.Background(), http.MethodGet, srv.URL+"/first", nil, nil,
+		WithRedirectCheck(func(u *url.URL) error {
+			if u.Path == "/final" {
+				return errDenied
+			}
+			return nil
+		}))
+	require.ErrorIs(t, err, errDenied)
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxySelector выбирает прокси для запроса по настройкам из конфигурации или
+// переменных окружения HTTP_PROXY, HTTPS_PROXY и NO_PROXY.
+//
+// Через прокси адрес назначения разрешает и подключается сам прокси, поэтому
+// проверка dialer'а к нему не применяется. Вместо нее имя хоста назначения
+// разрешается и проверяется при выборе прокси, а подключение без проверки
+// разрешается только к адресам выбранных прокси.
+type proxySelector struct {
+	proxyFunc func(*url.URL) (*url.URL, error)
+	guard     *ipGuard
+
+	mu    sync.RWMutex
+	addrs map[string]struct{}
+}
+
+// newProxySelector возвращает nil, если прокси не настроен ни в конфигурации, ни в окружении.
+func newProxySelector(conf config.ProxyConf, guard *ipGuard) (*proxySelector, error) {
+	var proxyConf *httpproxy.Config
+	switch {
+	case conf.URL != "":
+		proxyURL, err := url.Parse(conf.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy url: %w", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+		}
+		if conf.Username != "" {
+			proxyURL.User = url.UserPassword(conf.Username, conf.Password)
+		}
+
+		proxyConf = &httpproxy.Config{
+			HTTPProxy:  proxyURL.String(),
+			HTTPSProxy: proxyURL.String(),
+			NoProxy:    strings.Join(conf.NoProxy, ","),
+		}
+	case !conf.IgnoreEnv:
+		proxyConf = httpproxy.FromEnvironment()
+		if proxyConf.HTTPProxy == "" && proxyConf.HTTPSProxy == "" {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+
+	return &proxySelector{
+		proxyFunc: proxyConf.ProxyFunc(),
+		guard:     guard,
+		addrs:     make(map[string]struct{}),
+	}, nil
+}
+
+// proxy используется как http.Transport.Proxy.
+func (p *proxySelector) proxy(req *http.Request) (*url.URL, error) {
+	proxyURL, err := p.proxyFunc(req.URL)
+	if err != nil || proxyURL == nil {
+		return proxyURL, err
+	}
+
+	if err := p.guard.checkHost(req.Context(), req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.addrs[proxyAddr(proxyURL)] = struct{}{}
+	p.mu.Unlock()
+
+	return proxyURL, nil
+}
+
+// isProxy сообщает, является ли адрес адресом одного из выбранных прокси.
+func (p *proxySelector) isProxy(addr string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, ok := p.addrs[addr]
+	return ok
+}
+
+// proxyAddr возвращает адрес прокси в виде host:port, как его передает dialer'у http.Transport.
+func proxyAddr(proxyURL *url.URL) string {
+	if port := proxyURL.Port(); port != "" {
+		return net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	port := "80"
+	switch proxyURL.Scheme {
+	case "https":
+		port = "443"
+	case "socks5":
+		port = "1080"
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// dialContext подключается к выбранным прокси без проверки адреса,
+// а ко всем остальным адресам - через dialer с проверкой.
+func (p *proxySelector) dialContext(
+	guarded, direct *net.Dialer,
+) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if p.isProxy(addr) {
+			return direct.DialContext(ctx, network, addr)
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+// Адрес из сети для документации, напрямую он недоступен.
+const proxiedURL = "http://192.0.2.10/image.jpg"
+
+// Прокси-заглушка: сама отвечает на запросы, переданные ей как прокси, и запоминает их.
+func newHTTPProxy(t *testing.T) (*httptest.Server, *atomic.Value) {
+	t.Helper()
+
+	var last atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last.Store(r.Clone(context.Background()))
+		w.Write([]byte("proxied"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &last
+}
+
+func newProxyClient(t *testing.T, proxy config.ProxyConf) *Client {
+	t.Helper()
+
+	c, err := NewHTTPClient(config.ClientConf{AllowCIDRs: []string{"192.0.2.0/24"}, Proxy: proxy})
+	require.NoError(t, err)
+	return c
+}
+
+func readBody(t *testing.T, c *Client, rawURL string) string {
+	t.Helper()
+
+	resp, err := c.DoRequest(context.Background(), http.MethodGet, rawURL, nil, nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestProxyHTTP(t *testing.T) {
+	proxy, last := newHTTPProxy(t)
+	c := newProxyClient(t, config.ProxyConf{URL: proxy.URL, Username: "user", Password: "secret"})
+
+	require.Equal(t, "proxied", readBody(t, c, proxiedURL))
+
+	req := last.Load().(*http.Request)
+	require.Equal(t, proxiedURL, req.RequestURI)
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	require.Equal(t, auth, req.Header.Get("Proxy-Authorization"))
+}
+
+// Адрес назначения проверяется и при работе через прокси.
+func TestProxyForbiddenTarget(t *testing.T) {
+	proxy, last := newHTTPProxy(t)
+	c := newProxyClient(t, config.ProxyConf{URL: proxy.URL})
+
+	_, err := c.DoRequest(context.Background(), http.MethodGet, "http://10.0.0.1/image.jpg", nil, nil)
+	require.ErrorIs(t, err, ErrForbiddenAddress)
+	require.Nil(t, last.Load())
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	proxy, last := newHTTPProxy(t)
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("NO_PROXY", "")
+
+	// Переменные окружения учитываются по умолчанию, как в стандартном http.Transport
+	c := newProxyClient(t, config.ProxyConf{})
+	require.Equal(t, "proxied", readBody(t, c, proxiedURL))
+	require.Equal(t, proxiedURL, last.Load().(*http.Request).RequestURI)
+
+	guard, err := newIPGuard(nil, nil)
+	require.NoError(t, err)
+	selector, err := newProxySelector(config.ProxyConf{IgnoreEnv: true}, guard)
+	require.NoError(t, err)
+	require.Nil(t, selector)
+}
+
+func TestProxyNoProxy(t *testing.T) {
+	guard, err := newIPGuard(nil, nil)
+	require.NoError(t, err)
+	selector, err := newProxySelector(config.ProxyConf{
+		URL:     "http://proxy.local:3128",
+		NoProxy: []string{".internal.example", "192.0.2.0/24"},
+	}, guard)
+	require.NoError(t, err)
+
+	for _, rawURL := range []string{"http://cdn.internal.example/a.jpg", "http://192.0.2.10/a.jpg"} {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		require.NoError(t, err)
+		proxyURL, err := selector.proxy(req)
+		require.NoError(t, err)
+		require.Nil(t, proxyURL, rawURL)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://198.51.100.1/a.jpg", nil)
+	require.NoError(t, err)
+	proxyURL, err := selector.proxy(req)
+	require.NoError(t, err)
+	require.Equal(t, "proxy.local:3128", proxyURL.Host)
+	require.True(t, selector.isProxy("proxy.local:3128"))
+
+	_, err = newProxySelector(config.ProxyConf{URL: "ftp://proxy.local"}, guard)
+	require.Error(t, err)
+}
+
+func TestProxySOCKS5(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("via socks"))
+	}))
+	defer origin.Close()
+
+	var target atomic.Value
+	proxyAddr := newSOCKS5Proxy(t, origin.Listener.Addr().String(), &target)
+	c := newProxyClient(t, config.ProxyConf{URL: "socks5://" + proxyAddr})
+
+	require.Equal(t, "via socks", readBody(t, c, proxiedURL))
+	require.Equal(t, "192.0.2.10:80", target.Load())
+}
+
+// newSOCKS5Proxy запускает SOCKS5 заглушку без аутентификации, которая запоминает
+// запрошенный адрес, а соединение устанавливает с upstream.
+func newSOCKS5Proxy(t *testing.T, upstream string, target *atomic.Value) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, upstream, target)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func serveSOCKS5(conn net.Conn, upstream string, target *atomic.Value) {
+	defer conn.Close()
+
+	// Приветствие: версия, количество методов и методы, отвечаем "без аутентификации"
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// Запрос CONNECT: версия, команда, резерв, тип адреса, адрес и порт
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		io.ReadFull(conn, length)
+		name := make([]byte, length[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	target.Store(net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+
+	upstreamConn, err := net.Dial("tcp", upstream)
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstreamConn.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(upstreamConn, conn)
+	io.Copy(conn, upstreamConn)
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+)
+
+var ErrRateLimited = errors.New("origin request rate limit exceeded")
+
+// limiterSweepInterval как часто удаляются корзины хостов, к которым давно не было запросов.
+const limiterSweepInterval = time.Minute
+
+// WithRateLimit ограничивает частоту запросов к хосту. Запрос, для которого нет
+// свободного токена, ждет его не дольше MaxWait, иначе завершается с ErrRateLimited.
+func WithRateLimit(conf config.RateLimitConf) RequestOption {
+	return func(o *requestOptions) {
+		o.rateLimit = conf
+	}
+}
+
+// tokenBucket корзина токенов: пополняется со скоростью rate в секунду и вмещает
+// не больше burst токенов. Отрицательное количество означает токены, обещанные
+// ожидающим запросам.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// fullAt когда корзина наполнится, после этого она не отличается от новой.
+	fullAt time.Time
+}
+
+// limiters корзины токенов для каждого хоста источника.
+type limiters struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	hosts   map[string]*tokenBucket
+	sweptAt time.Time
+}
+
+func newLimiters() *limiters {
+	return &limiters{
+		now:   time.Now,
+		hosts: make(map[string]*tokenBucket),
+	}
+}
+
+// wait дожидается токена для запроса к хосту.
+func (l *limiters) wait(ctx context.Context, host string, conf config.RateLimitConf) error {
+	if conf.Rate <= 0 {
+		return nil
+	}
+
+	delay, err := l.reserve(ctx, host, conf)
+	if err != nil || delay == 0 {
+		return err
+	}
+
+	if err := sleep(ctx, delay); err != nil {
+		// Запрос не состоялся, возвращаем обещанный ему токен
+		l.cancel(host)
+		return err
+	}
+	return nil
+}
+
+// reserve забирает токен и возвращает, сколько нужно ждать, пока он станет доступен.
+func (l *limiters) reserve(ctx context.Context, host string, conf config.RateLimitConf) (time.Duration, error) {
+	burst := float64(max(conf.Burst, 1))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	bucket, ok := l.hosts[host]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.hosts[host] = bucket
+	}
+	bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*conf.Rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.take(burst, conf.Rate)
+		return 0, nil
+	}
+
+	delay := time.Duration((1 - bucket.tokens) / conf.Rate * float64(time.Second))
+	if delay > conf.MaxWait {
+		return 0, fmt.Errorf("%w: %s", ErrRateLimited, host)
+	}
+	if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+		return 0, fmt.Errorf("%w: %s", ErrRateLimited, host)
+	}
+
+	bucket.take(burst, conf.Rate)
+	return delay, nil
+}
+
+// take забирает токен из корзины.
+func (b *tokenBucket) take(burst, rate float64) {
+	b.tokens--
+	b.fullAt = b.last.Add(time.Duration((burst - b.tokens) / rate * float64(time.Second)))
+}
+
+// sweep не чаще раза в limiterSweepInterval удаляет наполнившиеся корзины: следующий
+// запрос к хосту создаст такую же полную корзину заново.
+func (l *limiters) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < limiterSweepInterval {
+		return
+	}
+	l.sweptAt = now
+
+	for host, bucket := range l.hosts {
+		if !now.Before(bucket.fullAt) {
+			delete(l.hosts, host)
+		}
+	}
+}
+
+func (l *limiters) cancel(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bucket, ok := l.hosts[host]; ok {
+		bucket.tokens++
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitersReserve(t *testing.T) {
+	now := time.Now()
+	l := newLimiters()
+	l.now = func() time.Time { return now }
+	conf := config.RateLimitConf{Rate: 2, Burst: 2, MaxWait: time.Second}
+	ctx := context.Background()
+
+	// Запросы в пределах burst выполняются без ожидания
+	for i := 0; i < 2; i++ {
+		delay, err := l.reserve(ctx, "a", conf)
+		require.NoError(t, err)
+		require.Zero(t, delay)
+	}
+
+	// Следующие ждут пополнения корзины
+	delay, err := l.reserve(ctx, "a", conf)
+	require.NoError(t, err)
+	require.Equal(t, 500*time.Millisecond, delay)
+	delay, err = l.reserve(ctx, "a", conf)
+	require.NoError(t, err)
+	require.Equal(t, time.Second, delay)
+
+	// Ожидание дольше MaxWait завершается ошибкой
+	_, err = l.reserve(ctx, "a", conf)
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	// Другие хосты ограничиваются независимо
+	delay, err = l.reserve(ctx, "b", conf)
+	require.NoError(t, err)
+	require.Zero(t, delay)
+
+	// Корзина пополняется со временем
+	now = now.Add(2 * time.Second)
+	delay, err = l.reserve(ctx, "a", conf)
+	require.NoError(t, err)
+	require.Zero(t, delay)
+}
+
+// Наполнившиеся корзины удаляются, неполные продолжают ограничивать запросы.
+func TestLimitersSweep(t *testing.T) {
+	now := time.Now()
+	l := newLimiters()
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := l.reserve(ctx, "fast", config.RateLimitConf{Rate: 2, Burst: 2})
+	require.NoError(t, err)
+	_, err = l.reserve(ctx, "slow", config.RateLimitConf{Rate: 0.01, Burst: 1})
+	require.NoError(t, err)
+
+	now = now.Add(limiterSweepInterval)
+	_, err = l.reserve(ctx, "other", config.RateLimitConf{Rate: 2, Burst: 2})
+	require.NoError(t, err)
+	require.NotContains(t, l.hosts, "fast")
+	require.Contains(t, l.hosts, "slow")
+
+	// Корзина медленного хоста еще не наполнилась
+	_, err = l.reserve(ctx, "slow", config.RateLimitConf{Rate: 0.01, Burst: 1})
+	require.ErrorIs(t, err, ErrRateLimited)
+}
+
+func TestClientRateLimit(t *testing.T) {
+	c := newTestClient(t)
+	srv, _ := newFlakyServer(t)
+
+	get := func(conf config.RateLimitConf) error {
+		resp, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil, WithRateLimit(conf))
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	require.NoError(t, get(config.RateLimitConf{Rate: 10, Burst: 1}))
+	require.ErrorIs(t, get(config.RateLimitConf{Rate: 10, Burst: 1}), ErrRateLimited)
+
+	// Запрос дожидается токена, если ожидание укладывается в MaxWait
+	start := time.Now()
+	require.NoError(t, get(config.RateLimitConf{Rate: 10, Burst: 1, MaxWait: time.Second}))
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+)
+
+const (
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 2 * time.Second
+)
+
+// WithRetry включает повтор запроса при сетевых ошибках и временной недоступности сервера.
+func WithRetry(conf config.RetryConf) RequestOption {
+	return func(o *requestOptions) {
+		o.retry = conf
+	}
+}
+
+// retryer выполняет повторные попытки идемпотентного запроса с экспоненциальной
+// задержкой со случайным разбросом, учитывая заголовок Retry-After.
+type retryer struct {
+	conf     config.RetryConf
+	deadline time.Time
+}
+
+func newRetryer(conf config.RetryConf) *retryer {
+	if conf.BaseDelay <= 0 {
+		conf.BaseDelay = defaultRetryBaseDelay
+	}
+	if conf.MaxDelay <= 0 {
+		conf.MaxDelay = defaultRetryMaxDelay
+	}
+
+	r := &retryer{conf: conf}
+	if conf.Deadline > 0 {
+		r.deadline = time.Now().Add(conf.Deadline)
+	}
+	return r
+}
+
+// do выполняет запрос, повторяя его, пока есть попытки и время до общего дедлайна.
+// Дедлайн ограничивает и сами попытки, в том числе загрузку тела итогового ответа.
+func (r *retryer) do(
+	ctx context.Context,
+	attempt func(ctx context.Context) (*http.Response, error),
+) (*http.Response, error) {
+	if r.deadline.IsZero() {
+		return r.attempts(ctx, attempt)
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, r.deadline)
+	resp, err := r.attempts(ctx, attempt)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Контекст нужен до конца чтения тела, отменяем его при закрытии
+	resp.Body = CancelOnClose(resp.Body, cancel)
+	return resp, nil
+}
+
+func (r *retryer) attempts(
+	ctx context.Context,
+	attempt func(ctx context.Context) (*http.Response, error),
+) (*http.Response, error) {
+	for n := 1; ; n++ {
+		resp, err := attempt(ctx)
+		if n >= r.conf.MaxAttempts || !isRetryable(ctx, resp, err) {
+			return resp, err
+		}
+
+		// Повторять раньше, чем просит Retry-After, нельзя, поэтому при ожидании дольше
+		// MaxDelay или остатка дедлайна повторы прекращаются и возвращается ответ источника
+		delay := r.backoff(n)
+		if after, ok := retryAfter(resp); ok && after > delay {
+			if after > r.conf.MaxDelay {
+				return resp, err
+			}
+			delay = after
+		}
+		if !r.deadline.IsZero() && time.Now().Add(delay).After(r.deadline) {
+			return resp, err
+		}
+
+		if resp != nil {
+			// Вычитываем тело, чтобы соединение вернулось в пул
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff возвращает задержку перед следующей попыткой: случайное значение
+// от нуля до экспоненциально растущей границы.
+func (r *retryer) backoff(attempt int) time.Duration {
+	ceiling := r.conf.MaxDelay
+	if shift := attempt - 1; shift < 32 {
+		if exp := r.conf.BaseDelay << shift; exp > 0 && exp < ceiling {
+			ceiling = exp
+		}
+	}
+
+	return rand.N(ceiling) //nolint:gosec // для разброса задержек криптостойкость не нужна
+}
+
+// isRetryable определяет, имеет ли смысл повторить запрос.
+func isRetryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.Is(err, ErrForbiddenAddress) || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			return false
+		}
+		return isNetworkError(err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isNetworkError сообщает, вызвана ли ошибка сбоем соединения с сервером, а не,
+// например, отказом в переходе по редиректу.
+func isNetworkError(err error) bool {
+	// *url.Error сам реализует net.Error, поэтому проверяем вложенную ошибку
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// retryAfter разбирает заголовок Retry-After в виде количества секунд или даты.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
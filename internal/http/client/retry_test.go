@@ -0,0 +1,244 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	c, err := NewHTTPClient(config.ClientConf{AllowCIDRs: []string{"127.0.0.0/8"}})
+	require.NoError(t, err)
+	return c
+}
+
+// Сервер, отвечающий заданными статусами по очереди, а затем 200.
+func newFlakyServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestRetryTransientStatuses(t *testing.T) {
+	c := newTestClient(t)
+	srv, calls := newFlakyServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusBadGateway)
+
+	resp, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil,
+		WithRetry(config.RetryConf{MaxAttempts: 4, BaseDelay: time.Millisecond}))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, int32(4), atomic.LoadInt32(calls))
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	c := newTestClient(t)
+	srv, calls := newFlakyServer(t, http.StatusGatewayTimeout, http.StatusGatewayTimeout, http.StatusGatewayTimeout)
+
+	resp, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil,
+		WithRetry(config.RetryConf{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Возвращается ответ последней попытки
+	require.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	require.Equal(t, int32(2), atomic.LoadInt32(calls))
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+	c := newTestClient(t)
+	srv, calls := newFlakyServer(t, http.StatusNotFound)
+
+	resp, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil,
+		WithRetry(config.RetryConf{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Equal(t, int32(1), atomic.LoadInt32(calls))
+
+	// Без политики повторов запрос выполняется один раз
+	srv, calls = newFlakyServer(t, http.StatusServiceUnavailable)
+	resp, err = c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
+
+// Повтор после разрыва соединения сервером.
+func TestRetryConnectionReset(t *testing.T) {
+	c := newTestClient(t)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	resp, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil,
+		WithRetry(config.RetryConf{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(data))
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+// Retry-After, превышающий общий дедлайн, прекращает повторы.
+func TestRetryAfterExceedsDeadline(t *testing.T) {
+	c := newTestClient(t)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil,
+		WithRetry(config.RetryConf{MaxAttempts: 5, BaseDelay: time.Millisecond, Deadline: time.Second}))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	require.Less(t, time.Since(start), time.Second)
+}
+
+// Retry-After дольше MaxDelay прекращает повторы: повторить раньше, чем просит
+// источник, нельзя, а ждать дольше MaxDelay не разрешено.
+func TestRetryAfterExceedsMaxDelay(t *testing.T) {
+	for _, deadline := range []time.Duration{0, 5 * time.Second} {
+		t.Run(deadline.String(), func(t *testing.T) {
+			c := newTestClient(t)
+
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.Header().Set("Retry-After", "10")
+				w.WriteHeader(http.StatusTooManyRequests)
+			}))
+			defer srv.Close()
+
+			start := time.Now()
+			resp, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil,
+				WithRetry(config.RetryConf{MaxAttempts: 3, MaxDelay: 50 * time.Millisecond, Deadline: deadline}))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+			require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+			require.Less(t, time.Since(start), time.Second)
+		})
+	}
+}
+
+// Retry-After в пределах MaxDelay выдерживается перед повтором.
+func TestRetryAfterWithinMaxDelay(t *testing.T) {
+	c := newTestClient(t)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil,
+		WithRetry(config.RetryConf{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Second, Deadline: 5 * time.Second}))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Тело ответа читается после возврата из DoRequest
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(data))
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	require.GreaterOrEqual(t, time.Since(start), time.Second)
+}
+
+// Дедлайн ограничивает не только задержки, но и зависшие попытки.
+func TestRetryDeadlineLimitsAttempts(t *testing.T) {
+	c := newTestClient(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	_, err := c.DoRequest(context.Background(), http.MethodGet, srv.URL, nil, nil,
+		WithRetry(config.RetryConf{MaxAttempts: 5, Deadline: 100 * time.Millisecond}))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	_, ok := retryAfter(resp)
+	require.False(t, ok)
+
+	resp.Header.Set("Retry-After", "2")
+	delay, ok := retryAfter(resp)
+	require.True(t, ok)
+	require.Equal(t, 2*time.Second, delay)
+
+	resp.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	delay, ok = retryAfter(resp)
+	require.True(t, ok)
+	require.InDelta(t, time.Minute, delay, float64(2*time.Second))
+
+	resp.Header.Set("Retry-After", "soon")
+	_, ok = retryAfter(resp)
+	require.False(t, ok)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	r := newRetryer(config.RetryConf{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+
+	for attempt := 1; attempt < 100; attempt++ {
+		delay := r.backoff(attempt)
+		require.GreaterOrEqual(t, delay, time.Duration(0))
+		require.Less(t, delay, 50*time.Millisecond)
+		if attempt == 1 {
+			require.Less(t, delay, 10*time.Millisecond)
+		}
+	}
+}
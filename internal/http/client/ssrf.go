@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+var ErrForbiddenAddress = errors.New("destination address is not allowed")
+
+// defaultDeniedCIDRs внутренние, служебные и зарезервированные диапазоны адресов,
+// запросы к которым запрещены, если в конфигурации не задан собственный список.
+var defaultDeniedCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// ipGuard проверяет адреса назначения по спискам разрешенных и запрещенных сетей.
+// Разрешающий список имеет приоритет над запрещающим.
+type ipGuard struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func newIPGuard(allowCIDRs, denyCIDRs []string) (*ipGuard, error) {
+	if len(denyCIDRs) == 0 {
+		denyCIDRs = defaultDeniedCIDRs
+	}
+
+	allow, err := parseCIDRs(allowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseCIDRs(denyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ipGuard{allow: allow, deny: deny}, nil
+}
+
+func (g *ipGuard) check(ip net.IP) error {
+	if containsIP(g.allow, ip) {
+		return nil
+	}
+	if containsIP(g.deny, ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+	}
+	return nil
+}
+
+// checkHost проверяет все адреса, в которые разрешается имя хоста. Используется, когда
+// подключение выполняет не сам клиент, а прокси.
+func (g *ipGuard) checkHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		return g.check(ip)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := g.check(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// control вызывается dialer'ом непосредственно перед подключением, когда имя уже
+// разрешено в IP адрес. Проверка в этот момент не позволяет обойти ее подменой
+// DNS ответа между проверкой и подключением, а также действует для редиректов.
+func (g *ipGuard) control(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return g.check(ip)
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("parse cidr %q: %w", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPGuard(t *testing.T) {
+	guard, err := newIPGuard([]string{"10.1.0.0/16"}, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{ip: "8.8.8.8", allowed: true},
+		{ip: "2001:4860:4860::8888", allowed: true},
+		{ip: "127.0.0.1", allowed: false},
+		{ip: "169.254.169.254", allowed: false},
+		{ip: "192.168.1.10", allowed: false},
+		{ip: "172.20.0.2", allowed: false},
+		{ip: "10.0.0.1", allowed: false},
+		{ip: "10.1.2.3", allowed: true},
+		{ip: "::1", allowed: false},
+		{ip: "::ffff:127.0.0.1", allowed: false},
+		{ip: "fd00::1", allowed: false},
+		// NAT64 транслирует адрес в IPv4, в том числе во внутренний
+		{ip: "64:ff9b::7f00:1", allowed: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.ip, func(t *testing.T) {
+			err := guard.check(net.ParseIP(tc.ip))
+			if tc.allowed {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, ErrForbiddenAddress)
+			}
+		})
+	}
+}
+
+// Имена, разрешающиеся во внутренние адреса, и редиректы на них блокируются при подключении.
+func TestClientBlocksInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer srv.Close()
+
+	c, err := NewHTTPClient(config.ClientConf{})
+	require.NoError(t, err)
+
+	for _, target := range []string{srv.URL, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)} {
+		resp, err := c.DoRequest(context.Background(), http.MethodGet, target, nil, nil)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		require.ErrorIs(t, err, ErrForbiddenAddress)
+	}
+}
@@ -3,4 +3,6 @@ package dto
 type Result struct {
 	Code    int32  `json:"code"`
 	Message string `json:"message"`
+	// UpstreamStatus код ответа удаленного сервера, если ошибка вызвана им.
+	UpstreamStatus int `json:"upstreamStatus,omitempty"`
 }
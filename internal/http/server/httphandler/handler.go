@@ -1,29 +1,36 @@
 package httphandler
 
 import (
-	"bytes"
 	"encoding/json"
-	"image/jpeg"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/Lanworm/image-previewer/internal/http/client"
 	"github.com/Lanworm/image-previewer/internal/http/server/dto"
 	"github.com/Lanworm/image-previewer/internal/logger"
 	"github.com/Lanworm/image-previewer/internal/service"
+	"github.com/Lanworm/image-previewer/internal/signature"
+	"github.com/gorilla/mux"
 )
 
 type Handler struct {
 	logger  *logger.Logger
 	service *service.ImageService
+	signer  *signature.Signer
 }
 
 func NewHandler(
 	logger *logger.Logger,
 	service *service.ImageService,
+	signer *signature.Signer,
 ) *Handler {
 	return &Handler{
 		logger:  logger,
 		service: service,
+		signer:  signer,
 	}
 }
 
@@ -31,48 +38,131 @@ func (h *Handler) ResizeHandler(
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	// Подготовка параметров изображения из запроса
-	imgParams, err := service.PrepareImgParams(r)
-	if err != nil {
-		// Обработка ошибки и отправка ответа с кодом 500
-		writeError(http.StatusInternalServerError, w, err.Error())
-		h.logger.Error(err.Error())
+	// Проверка подписи адреса до разбора параметров, чтобы неподписанный запрос не тратил ресурсы
+	if err := h.verifySignature(r); err != nil {
+		h.handleError(w, err)
 		return
 	}
 
-	// Изменение размера изображения
-	img, err := h.service.ResizeImg(imgParams, r)
+	// Подготовка параметров изображения из запроса
+	imgParams, err := h.service.PrepareImgParams(r)
 	if err != nil {
-		// Обработка ошибки и отправка ответа с кодом 500
-		writeError(http.StatusInternalServerError, w, err.Error())
-		h.logger.Error(err.Error())
+		// Обработка ошибки и отправка ответа с кодом, соответствующим ее причине
+		h.handleError(w, err)
 		return
 	}
 
-	// Кодирование изображения в формат JPEG
-	buf := new(bytes.Buffer)
-	err = jpeg.Encode(buf, img, nil)
+	// Изменение размера изображения, отмена запроса клиентом прерывает загрузку и обработку
+	preview, err := h.service.ResizeImg(r.Context(), imgParams, r.Header)
 	if err != nil {
-		// Обработка ошибки и отправка ответа с кодом 500
-		writeError(http.StatusInternalServerError, w, err.Error())
-		h.logger.Error(err.Error())
+		// Обработка ошибки и отправка ответа с кодом, соответствующим ее причине
+		h.handleError(w, err)
 		return
 	}
 
 	// Установка заголовков и отправка изображения в ответе
-	w.Header().Set("Content-Type", "image/jpeg")
-	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
-	w.Write(buf.Bytes())
+	w.Header().Set("Content-Type", preview.Meta.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(preview.Data)))
+	if preview.Meta.ETag != "" {
+		w.Header().Set("ETag", preview.Meta.ETag)
+	}
+	w.Header().Set("X-Cache", string(preview.CacheStatus))
+	w.Write(preview.Data)
+}
+
+// verifySignature проверяет подпись пути запроса /{signature}/fill/... Подписывается путь
+// после подписи вместе с параметрами запроса и сроком действия /exp/{unix}, если он задан.
+// Если ключи не заданы, подпись не проверяется.
+func (h *Handler) verifySignature(r *http.Request) error {
+	vars := mux.Vars(r)
+
+	if h.signer.Enabled() {
+		sig, ok := vars["signature"]
+		if !ok {
+			return signature.ErrSignatureRequired
+		}
+
+		path := strings.TrimPrefix(r.URL.EscapedPath(), "/"+sig)
+		if r.URL.RawQuery != "" {
+			path += "?" + r.URL.RawQuery
+		}
+		if err := h.signer.Verify(sig, path); err != nil {
+			return err
+		}
+	}
+
+	// Срок действия проверяется после подписи, которая защищает его от изменения
+	if expires, ok := vars["expires"]; ok {
+		return h.signer.CheckExpiry(expires)
+	}
+	return nil
+}
+
+// StatusClientClosedRequest нестандартный код ответа для запросов, прерванных клиентом.
+const StatusClientClosedRequest = 499
+
+// handleError отправляет ошибку обработки изображения и логирует ее.
+func (h *Handler) handleError(w http.ResponseWriter, err error) {
+	statusCode := errorStatus(err)
+	result := dto.Result{Message: err.Error()}
+	var upstreamErr *service.UpstreamStatusError
+	if errors.As(err, &upstreamErr) {
+		result.UpstreamStatus = upstreamErr.StatusCode
+	}
+	writeResult(statusCode, w, result)
+
+	if statusCode == StatusClientClosedRequest {
+		h.logger.Warning(fmt.Sprintf("%d client closed request: %s", statusCode, err))
+		return
+	}
+	h.logger.Error(fmt.Sprintf("%d %s", statusCode, err))
+}
+
+// errorStatus возвращает код ответа для ошибки сервиса.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrRequestCanceled):
+		return StatusClientClosedRequest
+	case errors.Is(err, service.ErrOriginTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, client.ErrForbiddenAddress),
+		errors.Is(err, service.ErrOriginNotAllowed),
+		errors.Is(err, service.ErrHTTPSRequired),
+		errors.Is(err, service.ErrLocalPathForbidden),
+		errors.Is(err, signature.ErrSignatureRequired),
+		errors.Is(err, signature.ErrInvalidSignature):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrUpstreamStatus),
+		errors.Is(err, client.ErrTooManyRedirects):
+		return http.StatusBadGateway
+	case errors.Is(err, service.ErrInvalidURL),
+		errors.Is(err, service.ErrInvalidFormatOfArguments),
+		errors.Is(err, service.ErrInvalidArgumentTypeOfWidthOrHeight):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrImageSize),
+		errors.Is(err, service.ErrImageDimensions):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, signature.ErrURLExpired):
+		return http.StatusGone
+	case errors.Is(err, service.ErrImageNotFound),
+		errors.Is(err, service.ErrUnknownAlias):
+		return http.StatusNotFound
+	case errors.Is(err, client.ErrCircuitOpen),
+		errors.Is(err, client.ErrRateLimited):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
-// Функция для отправки ошибки в ответе.
-func writeError(
+// writeResult отправляет описание ошибки в формате JSON.
+func writeResult(
 	statusCode int,
 	w http.ResponseWriter,
-	msg string,
+	result dto.Result,
 ) {
 	// Создание JSON с сообщением об ошибке
-	js, err := json.Marshal(dto.Result{Message: msg})
+	js, err := json.Marshal(result)
 	if err != nil {
 		// Если возникла ошибка при сериализации, отправляем код 500
 		w.WriteHeader(http.StatusInternalServerError)
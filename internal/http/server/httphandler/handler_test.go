@@ -0,0 +1,139 @@
+package httphandler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/Lanworm/image-previewer/internal/http/client"
+	"github.com/Lanworm/image-previewer/internal/service"
+	"github.com/Lanworm/image-previewer/internal/signature"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	currentKey = "current-key-0123456789"
+	oldKey     = "old-key-0123456789"
+)
+
+// verifyStatus возвращает код ответа на проверку подписи адреса target, маршрутизируя
+// запрос так же, как сервер.
+func verifyStatus(t *testing.T, signer *signature.Signer, target string) int {
+	t.Helper()
+
+	h := NewHandler(nil, nil, signer)
+	router := mux.NewRouter().UseEncodedPath()
+	verify := func(w http.ResponseWriter, r *http.Request) {
+		if err := h.verifySignature(r); err != nil {
+			w.WriteHeader(errorStatus(err))
+		}
+	}
+	router.HandleFunc("/fill/{width}/{height}/{url:.*}", verify)
+	router.HandleFunc("/{signature}/fill/{width}/{height}/{url:.*}", verify)
+	router.HandleFunc("/{signature}/exp/{expires:[0-9]+}/fill/{width}/{height}/{url:.*}", verify)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec.Code
+}
+
+// signed возвращает путь, подписанный ключом key.
+func signed(key, path string) string {
+	return "/" + signature.NewSigner(config.SigningConf{Keys: []string{key}}).Sign(path) + path
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{err: service.ErrRequestCanceled, status: StatusClientClosedRequest},
+		// Некорректный адрес или размеры - ошибка клиента
+		{err: service.ErrInvalidURL, status: http.StatusBadRequest},
+		{err: service.ErrInvalidFormatOfArguments, status: http.StatusBadRequest},
+		{err: service.ErrInvalidArgumentTypeOfWidthOrHeight, status: http.StatusBadRequest},
+		{err: service.ErrOriginTimeout, status: http.StatusGatewayTimeout},
+		{err: client.ErrForbiddenAddress, status: http.StatusForbidden},
+		{err: signature.ErrInvalidSignature, status: http.StatusForbidden},
+		{err: signature.ErrURLExpired, status: http.StatusGone},
+		{err: service.ErrImageNotFound, status: http.StatusNotFound},
+		{err: service.ErrUpstreamStatus, status: http.StatusBadGateway},
+		{err: client.ErrCircuitOpen, status: http.StatusServiceUnavailable},
+		// Изображение, превышающее лимиты, не ошибка сервера
+		{err: service.ErrImageSize, status: http.StatusUnprocessableEntity},
+		{err: service.ErrImageDimensions, status: http.StatusUnprocessableEntity},
+		{err: context.Canceled, status: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			require.Equal(t, tc.status, errorStatus(tc.err))
+			// Ошибки распознаются и в обертке
+			require.Equal(t, tc.status, errorStatus(fmt.Errorf("load image: %w", tc.err)))
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	signer := signature.NewSigner(config.SigningConf{Keys: []string{currentKey, oldKey}})
+	path := "/fill/300/200/https%3A%2F%2Fcdn.example.com%2Fa.jpg"
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{name: "signed", target: signed(currentKey, path), status: http.StatusOK},
+		// Адреса, подписанные прежним ключом, продолжают работать
+		{name: "old key", target: signed(oldKey, path), status: http.StatusOK},
+		{name: "unknown key", target: signed("unknown-key-0123456789", path), status: http.StatusForbidden},
+		{name: "query", target: signed(currentKey, path+"?v=2"), status: http.StatusOK},
+		{name: "changed query", target: "/" + signer.Sign(path+"?v=2") + path + "?v=3", status: http.StatusForbidden},
+		{name: "dropped query", target: "/" + signer.Sign(path+"?v=2") + path, status: http.StatusForbidden},
+		{name: "missing", target: path, status: http.StatusForbidden},
+		{name: "malformed", target: "/not*base64" + path, status: http.StatusForbidden},
+		{name: "other path", target: "/" + signer.Sign(path) + "/fill/3000/2000/https%3A%2F%2Fcdn.example.com%2Fa.jpg", status: http.StatusForbidden},
+		{
+			// Путь подписывается после очистки, иначе маршрутизатор ответил бы редиректом
+			name:   "cleaned",
+			target: signed(currentKey, signature.CleanPath("/fill/300/200/https://cdn.example.com/a.jpg")),
+			status: http.StatusOK,
+		},
+		{
+			name:   "not cleaned",
+			target: signed(currentKey, "/fill/300/200/https://cdn.example.com/a.jpg"),
+			status: http.StatusMovedPermanently,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.status, verifyStatus(t, signer, tc.target), tc.target)
+		})
+	}
+
+	// Без ключей подпись не требуется
+	require.Equal(t, http.StatusOK, verifyStatus(t, signature.NewSigner(config.SigningConf{}), path))
+}
+
+func TestVerifySignatureExpiry(t *testing.T) {
+	signer := signature.NewSigner(config.SigningConf{Keys: []string{currentKey}})
+	path := "/fill/300/200/https%3A%2F%2Fcdn.example.com%2Fa.jpg"
+	valid := signature.ExpiringPath(path, time.Now().Add(time.Hour))
+	expired := signature.ExpiringPath(path, time.Now().Add(-time.Hour))
+
+	require.Equal(t, http.StatusOK, verifyStatus(t, signer, signed(currentKey, valid)))
+	require.Equal(t, http.StatusGone, verifyStatus(t, signer, signed(currentKey, expired)))
+
+	// Продлить адрес, не зная ключа, нельзя: срок входит в подпись
+	sig := signer.Sign(expired)
+	require.Equal(t, http.StatusForbidden, verifyStatus(t, signer, "/"+sig+valid))
+
+	// Срок без подписи не принимается
+	require.Equal(t, http.StatusNotFound, verifyStatus(t, signer, valid))
+}
@@ -0,0 +1,39 @@
+package httphandler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Lanworm/image-previewer/internal/http/client"
+)
+
+// MetricsHandler отдает метрики в текстовом формате Prometheus.
+type MetricsHandler struct {
+	client *client.Client
+}
+
+func NewMetricsHandler(client *client.Client) *MetricsHandler {
+	return &MetricsHandler{client: client}
+}
+
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	stats := h.client.BreakerStats()
+
+	b := new(strings.Builder)
+	b.WriteString("# HELP previewer_origin_circuit_state Circuit breaker state per origin host " +
+		"(0 - closed, 1 - open, 2 - half-open).\n")
+	b.WriteString("# TYPE previewer_origin_circuit_state gauge\n")
+	for _, stat := range stats {
+		fmt.Fprintf(b, "previewer_origin_circuit_state{host=%s} %d\n", strconv.Quote(stat.Host), stat.State)
+	}
+	b.WriteString("# HELP previewer_origin_circuit_opens_total Number of times the circuit breaker has opened.\n")
+	b.WriteString("# TYPE previewer_origin_circuit_opens_total counter\n")
+	for _, stat := range stats {
+		fmt.Fprintf(b, "previewer_origin_circuit_opens_total{host=%s} %d\n", strconv.Quote(stat.Host), stat.Opens)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -4,6 +4,11 @@ import (
 	"github.com/Lanworm/image-previewer/internal/http/server/httphandler"
 )
 
-func (s *Server) RegisterRoutes(handler *httphandler.Handler) {
+func (s *Server) RegisterRoutes(handler *httphandler.Handler, metrics *httphandler.MetricsHandler) {
 	s.AddRoute("/fill/{width}/{height}/{url:.*}", handler.ResizeHandler)
+	// Подписанный адрес: /{signature}/fill/{width}/{height}/{url}
+	s.AddRoute("/{signature}/fill/{width}/{height}/{url:.*}", handler.ResizeHandler)
+	// Подписанный адрес со сроком действия: /{signature}/exp/{unix}/fill/{width}/{height}/{url}
+	s.AddRoute("/{signature}/exp/{expires:[0-9]+}/fill/{width}/{height}/{url:.*}", handler.ResizeHandler)
+	s.AddRoute("/metrics", metrics.ServeHTTP)
 }
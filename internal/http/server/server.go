@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/Lanworm/image-previewer/internal/config"
 	"github.com/Lanworm/image-previewer/internal/logger"
@@ -16,7 +18,12 @@ type Server struct {
 	conf   config.ServerHTTPConf
 	srv    *http.Server
 	mux    *mux.Router
-	done   bool
+	// started и done выставляются из разных горутин: Start работает в своей, а Stop
+	// может быть вызван по сигналу еще до того, как Start начал выполняться
+	started atomic.Bool
+	done    atomic.Bool
+	// cancel отменяет контексты всех запросов, которые не успели завершиться при остановке
+	cancel context.CancelFunc
 }
 
 func NewHTTPServer(
@@ -27,21 +34,20 @@ func NewHTTPServer(
 		conf.Protocol = "tcp4"
 	}
 
-	return &Server{
+	s := &Server{
 		logger: logger,
 		conf:   conf,
-		mux:    mux.NewRouter(),
-	}
-}
-
-func (s *Server) Start() error {
-	if s.srv != nil {
-		return errors.New("http server already started")
+		// Адрес изображения в пути может быть закодирован, поэтому переменные маршрута не декодируются
+		mux: mux.NewRouter().UseEncodedPath(),
 	}
 
 	lw := NewLogMiddleware(s.logger)
 	lc := NewRecoveryMiddleware(s.logger)
 
+	// Базовый контекст и сервер создаются сразу, чтобы Stop был безопасен до вызова Start
+	baseCtx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
 	s.srv = &http.Server{
 		Addr:              s.conf.GetFullAddress(),
 		Handler:           lc.Wrap(lw.Wrap(s.mux)),
@@ -51,10 +57,21 @@ func (s *Server) Start() error {
 		WriteTimeout:      s.conf.Timeout,
 		IdleTimeout:       s.conf.Timeout,
 		MaxHeaderBytes:    1 << 10,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
+	}
+
+	return s
+}
+
+func (s *Server) Start() error {
+	if !s.started.CompareAndSwap(false, true) {
+		return errors.New("http server already started")
 	}
 
 	err := s.srv.ListenAndServe()
-	if err != nil && !(errors.Is(err, http.ErrServerClosed) && s.done) {
+	if err != nil && !(errors.Is(err, http.ErrServerClosed) && s.done.Load()) {
 		return fmt.Errorf("http listen and serve at {%s}: %w", s.conf.GetFullAddress(), err)
 	}
 
@@ -62,9 +79,13 @@ func (s *Server) Start() error {
 }
 
 func (s *Server) Stop(ctx context.Context) error {
-	s.done = true
+	s.done.Store(true)
+
+	// Сначала даем запросам завершиться, затем прерываем оставшиеся загрузки
+	err := s.srv.Shutdown(ctx)
+	s.cancel()
 
-	return s.srv.Shutdown(ctx)
+	return err
 }
 
 func (s *Server) AddRoute(route string, handlerFunc http.HandlerFunc) {
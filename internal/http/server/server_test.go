@@ -0,0 +1,21 @@
+package server
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/Lanworm/image-previewer/internal/logger"
+	"github.com/stretchr/testify/require"
+)
+
+// Сигнал остановки может прийти раньше, чем горутина с Start начнет работать.
+func TestStopBeforeStart(t *testing.T) {
+	logg, err := logger.New("ERROR", io.Discard)
+	require.NoError(t, err)
+
+	s := NewHTTPServer(logg, config.ServerHTTPConf{Host: "127.0.0.1"})
+	require.NoError(t, s.Stop(context.Background()))
+	require.NoError(t, s.Start())
+}
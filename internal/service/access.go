@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/logger"
+	"github.com/Lanworm/image-previewer/internal/storage"
+)
+
+// accessFlushInterval как часто накопленные обращения к превью записываются в хранилище.
+const accessFlushInterval = 30 * time.Second
+
+// accessLog накапливает обращения к превью и записывает их в хранилище пачкой. Без этого
+// время последнего обращения не менялось бы у самых популярных превью: из кэша они отдаются,
+// не доходя до хранилища, а некоторые хранилища не обновляют его при чтении. Сборщик мусора
+// хранилища тогда удалял бы такие превью первыми.
+type accessLog struct {
+	storage  storage.Storage
+	logger   *logger.Logger
+	interval time.Duration
+
+	mu        sync.Mutex
+	pending   map[string]struct{}
+	flushedAt time.Time
+	flushing  sync.WaitGroup
+}
+
+func newAccessLog(storage storage.Storage, logger *logger.Logger) *accessLog {
+	return &accessLog{
+		storage:   storage,
+		logger:    logger,
+		interval:  accessFlushInterval,
+		pending:   make(map[string]struct{}),
+		flushedAt: time.Now(),
+	}
+}
+
+// record отмечает обращение к превью и, если с прошлой записи прошло достаточно времени,
+// записывает накопленные обращения в фоне.
+func (a *accessLog) record(id string) {
+	a.mu.Lock()
+	a.pending[id] = struct{}{}
+	due := time.Since(a.flushedAt) >= a.interval
+	if due {
+		a.flushedAt = time.Now()
+	}
+	a.mu.Unlock()
+
+	if due {
+		a.flushing.Add(1)
+		go func() {
+			defer a.flushing.Done()
+			a.flush()
+		}()
+	}
+}
+
+// close дожидается фоновых записей и записывает оставшиеся обращения.
+func (a *accessLog) close() {
+	a.flushing.Wait()
+	a.flush()
+}
+
+// flush записывает накопленные обращения в хранилище.
+func (a *accessLog) flush() {
+	a.mu.Lock()
+	ids := a.pending
+	a.pending = make(map[string]struct{})
+	a.mu.Unlock()
+
+	for id := range ids {
+		// Превью могло быть удалено из хранилища, пока оставалось в кэше
+		if err := a.storage.Touch(id); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			a.logger.Error("record access in storage: " + err.Error())
+		}
+	}
+}
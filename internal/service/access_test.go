@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/Lanworm/image-previewer/internal/storage/filestorage"
+	"github.com/stretchr/testify/require"
+)
+
+// Превью, которое отдается из кэша, не удаляется сборщиком мусора раньше более старых по обращениям.
+func TestCacheHitSurvivesGC(t *testing.T) {
+	store := filestorage.NewFileStorage(t.TempDir())
+	store.SetQuota(filestorage.Quota{MaxFiles: 1})
+	s := newTestServiceWithStorage(t, store, config.OriginsConf{})
+	origin := newChunkedOrigin(t, noiseJPEG(t, 32))
+
+	popular := &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/popular.jpg"}
+	other := &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/other.jpg"}
+
+	for _, params := range []*ImgParams{popular, other, popular} {
+		_, err := s.ResizeImg(context.Background(), params, nil)
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.access.flush()
+
+	removed, err := store.CollectGarbage()
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+
+	ids, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+	meta, err := store.GetMetadata(ids[0])
+	require.NoError(t, err)
+	require.Equal(t, popular.URL, meta.SourceURL)
+}
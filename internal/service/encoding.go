@@ -0,0 +1,61 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// decodeImage декодирует изображение, предварительно проверяя его размеры по заголовку,
+// чтобы не выделять память под изображения с огромным заявленным разрешением.
+func decodeImage(r io.Reader, maxPixels int64) (image.Image, string, error) {
+	// Прочитанный при разборе заголовка префикс сохраняем для последующего полного декодирования
+	prefix := new(bytes.Buffer)
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, prefix))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, "", ErrImageDimensions
+	}
+
+	return image.Decode(io.MultiReader(prefix, r))
+}
+
+// encodeImage кодирует изображение в исходном формате, неизвестные форматы кодируются в JPEG.
+func encodeImage(img image.Image, format string, quality int) ([]byte, string, error) {
+	buf := new(bytes.Buffer)
+
+	var (
+		contentType string
+		err         error
+	)
+	switch format {
+	case "png":
+		contentType = "image/png"
+		err = png.Encode(buf, img)
+	case "gif":
+		contentType = "image/gif"
+		err = gif.Encode(buf, img, nil)
+	default:
+		contentType = "image/jpeg"
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), contentType, nil
+}
+
+// getETag вычисляет строгий ETag по содержимому превью.
+func getETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/http/client"
+	"github.com/Lanworm/image-previewer/internal/storage"
+)
+
+// CacheStatus показывает, как было получено превью, передается клиенту в заголовке X-Cache.
+type CacheStatus string
+
+const (
+	// CacheHit актуальное превью из кэша или хранилища, в том числе подтвержденное источником.
+	CacheHit CacheStatus = "HIT"
+	// CacheMiss превью создано заново из загруженного изображения.
+	CacheMiss CacheStatus = "MISS"
+	// CacheStale устаревшее превью, отданное без подтверждения источником.
+	CacheStale CacheStatus = "STALE"
+)
+
+// expiredFor возвращает, истек ли срок актуальности превью и как давно.
+func (s *ImageService) expiredFor(meta storage.Metadata) (time.Duration, bool) {
+	if s.ttl <= 0 {
+		return 0, false
+	}
+
+	// У превью, сохраненных до появления проверки актуальности, отсчитываем срок от создания
+	validatedAt := meta.ValidatedAt
+	if validatedAt.IsZero() {
+		validatedAt = meta.CreatedAt
+	}
+
+	expiredFor := time.Since(validatedAt) - s.ttl
+	return expiredFor, expiredFor >= 0
+}
+
+// canServeStale проверяет, можно ли вместо ошибки отдать устаревшее превью:
+// ошибка должна говорить о недоступности источника, а превью устареть не больше чем на staleIfError.
+func (s *ImageService) canServeStale(meta storage.Metadata, err error) bool {
+	expiredFor, _ := s.expiredFor(meta)
+	return expiredFor < s.staleIfError && isOriginFailure(err)
+}
+
+// isOriginFailure определяет, вызвана ли ошибка сбоем источника: ответом 5xx,
+// таймаутом, сетевой ошибкой, разомкнутым предохранителем или ограничением частоты запросов.
+func isOriginFailure(err error) bool {
+	var upstreamErr *UpstreamStatusError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.StatusCode >= http.StatusInternalServerError
+	}
+	if errors.Is(err, client.ErrForbiddenAddress) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, ErrOriginTimeout) ||
+		errors.Is(err, ErrServerDoesNotExist) ||
+		errors.Is(err, client.ErrCircuitOpen) ||
+		errors.Is(err, client.ErrRateLimited) ||
+		errors.As(err, &netErr)
+}
+
+// refreshInBackground обновляет устаревшее превью в фоне. Одновременно для одного
+// превью выполняется не больше одного обновления.
+func (s *ImageService) refreshInBackground(
+	ctx context.Context,
+	imageID string,
+	imgParams *ImgParams,
+	headers http.Header,
+	cached *Preview,
+) {
+	s.mu.Lock()
+	if _, ok := s.refreshing[imageID]; ok {
+		s.mu.Unlock()
+		return
+	}
+	s.refreshing[imageID] = struct{}{}
+	s.mu.Unlock()
+
+	// Клиент уже получил ответ, поэтому обновление не должно прерываться вместе с его запросом
+	ctx = context.WithoutCancel(ctx)
+	params := *imgParams
+	headers = headers.Clone()
+
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.refreshing, imageID)
+			s.mu.Unlock()
+		}()
+
+		if _, err := s.fetch(ctx, imageID, &params, headers, cached); err != nil {
+			s.logger.Warning("background refresh of " + params.URL + ": " + err.Error())
+		}
+	}()
+}
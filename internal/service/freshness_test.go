@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Удаленный сервер, отдающий изображение или, после переключения, заданный код ошибки.
+func newSwitchableOrigin(t *testing.T, data []byte) (*httptest.Server, *int32, *int32) {
+	t.Helper()
+
+	var requests, failStatus int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if status := atomic.LoadInt32(&failStatus); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests, &failStatus
+}
+
+func TestResizeImgCacheStatus(t *testing.T) {
+	s := newTestService(t)
+	origin, _, _ := newSwitchableOrigin(t, noiseJPEG(t, 16))
+	params := &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/image.jpg"}
+
+	preview, err := s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	require.Equal(t, CacheMiss, preview.CacheStatus)
+
+	preview, err = s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	require.Equal(t, CacheHit, preview.CacheStatus)
+}
+
+// Недавно устаревшее превью отдается сразу, а обновляется в фоне.
+func TestResizeImgStaleWhileRevalidate(t *testing.T) {
+	s := newTestService(t)
+	s.ttl = 50 * time.Millisecond
+	s.staleWhileRevalidate = time.Hour
+	origin, requests, _ := newSwitchableOrigin(t, noiseJPEG(t, 16))
+	params := &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/image.jpg"}
+
+	_, err := s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+
+	preview, err := s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	require.Equal(t, CacheStale, preview.CacheStatus)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(requests) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	// После фонового обновления превью снова актуально
+	require.Eventually(t, func() bool {
+		preview, err := s.ResizeImg(context.Background(), params, nil)
+		return err == nil && preview.CacheStatus == CacheHit
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, int32(2), atomic.LoadInt32(requests))
+}
+
+// При сбое источника отдается устаревшее превью, а при ответе 404 - ошибка.
+func TestResizeImgStaleIfError(t *testing.T) {
+	s := newTestService(t)
+	s.ttl = 50 * time.Millisecond
+	s.staleIfError = time.Hour
+	origin, _, failStatus := newSwitchableOrigin(t, noiseJPEG(t, 16))
+	params := &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/image.jpg"}
+
+	first, err := s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+
+	atomic.StoreInt32(failStatus, http.StatusServiceUnavailable)
+	preview, err := s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	require.Equal(t, CacheStale, preview.CacheStatus)
+	require.Equal(t, first.Data, preview.Data)
+
+	atomic.StoreInt32(failStatus, http.StatusNotFound)
+	_, err = s.ResizeImg(context.Background(), params, nil)
+	require.ErrorIs(t, err, ErrImageNotFound)
+
+	// За пределами окна устаревшее превью не отдается
+	s.staleIfError = time.Millisecond
+	atomic.StoreInt32(failStatus, http.StatusServiceUnavailable)
+	_, err = s.ResizeImg(context.Background(), params, nil)
+	require.ErrorIs(t, err, ErrUpstreamStatus)
+}
+
+// Wait дожидается фонового обновления, чтобы хранилище можно было закрыть после него.
+func TestWaitForBackgroundRefresh(t *testing.T) {
+	s := newTestService(t)
+	s.ttl = 50 * time.Millisecond
+	s.staleWhileRevalidate = time.Hour
+	data := noiseJPEG(t, 16)
+
+	var requests int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(data)
+	}))
+	defer origin.Close()
+	params := &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/image.jpg"}
+
+	first, err := s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+
+	preview, err := s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	require.Equal(t, CacheStale, preview.CacheStatus)
+
+	s.Wait()
+	require.Empty(t, s.refreshing)
+	meta, err := s.storage.GetMetadata(getURLHash("resize1010" + params.URL))
+	require.NoError(t, err)
+	require.True(t, meta.ValidatedAt.After(first.Meta.ValidatedAt))
+}
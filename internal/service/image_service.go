@@ -1,43 +1,110 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"image"
+	"image/jpeg"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	lrucache "github.com/Lanworm/image-previewer/internal/cache"
+	"github.com/Lanworm/image-previewer/internal/config"
 	"github.com/Lanworm/image-previewer/internal/http/client"
 	"github.com/Lanworm/image-previewer/internal/logger"
 	"github.com/Lanworm/image-previewer/internal/storage"
-	"github.com/nfnt/resize"
 )
 
+// defaultMaxMegapixels ограничение разрешения исходного изображения по умолчанию.
+const defaultMaxMegapixels = 50
+
 type ImageService struct {
 	logger       *logger.Logger
 	storage      storage.Storage
 	cache        lrucache.Cache
+	access       *accessLog
+	client       *client.Client
+	origins      *originPolicy
+	local        *localOrigins
 	maxImageSize int
+	maxPixels    int64
+	quality      int
+	// resizeSlots ограничивает количество одновременных изменений размера
+	resizeSlots chan struct{}
+	ttl         time.Duration
+	// Окна, в течение которых после истечения ttl можно отдавать устаревшее превью
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+
+	mu         sync.Mutex
+	refreshing map[string]struct{}
+	// background фоновые обновления превью, которые пишут в хранилище
+	background sync.WaitGroup
 }
 
 func NewImageService(
 	logger *logger.Logger,
 	storage storage.Storage,
 	cache lrucache.Cache,
-	maxImageSize int,
+	client *client.Client,
+	conf config.ServiceConf,
+	origins config.OriginsConf,
 ) *ImageService {
+	quality := conf.Quality
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+	maxMegapixels := conf.MaxMegapixels
+	if maxMegapixels == 0 {
+		maxMegapixels = defaultMaxMegapixels
+	}
+	maxResizes := conf.MaxConcurrentResizes
+	if maxResizes == 0 {
+		maxResizes = runtime.GOMAXPROCS(0)
+	}
+
 	return &ImageService{
 		logger:       logger,
 		storage:      storage,
 		cache:        cache,
-		maxImageSize: maxImageSize,
+		access:       newAccessLog(storage, logger),
+		client:       client,
+		origins:      newOriginPolicy(origins),
+		local:        newLocalOrigins(origins.Local),
+		maxImageSize: conf.Size,
+		maxPixels:    int64(maxMegapixels * 1e6),
+		quality:      quality,
+		resizeSlots:  make(chan struct{}, maxResizes),
+		ttl:          conf.TTL,
+
+		staleWhileRevalidate: conf.StaleWhileRevalidate,
+		staleIfError:         conf.StaleIfError,
+		refreshing:           make(map[string]struct{}),
 	}
 }
 
+// Wait дожидается завершения фоновых обновлений превью и записывает накопленные обращения
+// к превью. Вызывается при остановке после сервера и до закрытия хранилища.
+func (s *ImageService) Wait() {
+	s.background.Wait()
+	s.access.close()
+}
+
+// Preview закодированное превью, готовое к отдаче клиенту.
+type Preview struct {
+	Data        []byte
+	Meta        storage.Metadata
+	CacheStatus CacheStatus
+}
+
 type ImgParams struct {
 	Width  int `validate:"required,gt=0,lte=9999"`
 	Height int `validate:"required,gt=0,lte=9999"`
@@ -48,78 +115,373 @@ var (
 	ErrImageNotFound      = errors.New("image not found on remote server")
 	ErrTargetNotImage     = errors.New("target file is not an image")
 	ErrImageSize          = errors.New("image size exceeds the limit")
+	ErrImageDimensions    = errors.New("image dimensions exceed the limit")
 	ErrServerDoesNotExist = errors.New("remote server does not exist")
+	ErrRequestCanceled    = errors.New("request canceled by client")
+	ErrOriginTimeout      = errors.New("remote server did not respond in time")
+	ErrUpstreamStatus     = errors.New("remote server returned an error")
 )
 
-func (s *ImageService) ResizeImg(imgParams *ImgParams, r *http.Request) (img image.Image, err error) {
+// UpstreamStatusError ответ удаленного сервера с кодом, отличным от 2xx.
+type UpstreamStatusError struct {
+	StatusCode int
+}
+
+func (e *UpstreamStatusError) Error() string {
+	status := strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode)
+	if e.StatusCode == http.StatusNotFound {
+		return fmt.Sprintf("%s: upstream status %s", ErrImageNotFound, status)
+	}
+	return fmt.Sprintf("%s: upstream status %s", ErrUpstreamStatus, status)
+}
+
+// Unwrap позволяет проверять ошибку через errors.Is с ErrUpstreamStatus,
+// а для ответа 404 также с ErrImageNotFound.
+func (e *UpstreamStatusError) Unwrap() []error {
+	if e.StatusCode == http.StatusNotFound {
+		return []error{ErrUpstreamStatus, ErrImageNotFound}
+	}
+	return []error{ErrUpstreamStatus}
+}
+
+func (s *ImageService) ResizeImg(ctx context.Context, imgParams *ImgParams, headers http.Header) (*Preview, error) {
 	// Получаем уникальный идентификатор изображения на основе его ссылки и размеров для изменения
 	imageID := getURLHash("resize" + strconv.Itoa(imgParams.Width) + strconv.Itoa(imgParams.Height) + imgParams.URL)
 
-	// Проверяем наличие изображения в кэше
-	cachedImg, ok := s.cache.Get(lrucache.Key(imageID))
+	// Ищем превью в кэше и хранилище, актуальное отдаем сразу
+	cached := s.lookup(imageID)
+	if cached != nil {
+		expiredFor, expired := s.expiredFor(cached.Meta)
+		if !expired {
+			cached.CacheStatus = CacheHit
+			return cached, nil
+		}
+		if expiredFor < s.staleWhileRevalidate {
+			// Недавно устаревшее превью отдаем сразу, а обновляем в фоне
+			s.refreshInBackground(ctx, imageID, imgParams, headers, cached)
+			cached.CacheStatus = CacheStale
+			return cached, nil
+		}
+	}
 
-	// Если изображение найдено в кэше, отдаем его
-	if ok {
-		fmt.Println("received from cache: ", imageID)
-		return cachedImg, nil
+	preview, err := s.fetch(ctx, imageID, imgParams, headers, cached)
+	if err != nil {
+		// Если источник недоступен, лучше отдать устаревшее превью, чем ошибку
+		if cached != nil && s.canServeStale(cached.Meta, err) {
+			s.logger.Warning("serving stale preview for " + imgParams.URL + ": " + err.Error())
+			cached.CacheStatus = CacheStale
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+// fetch загружает изображение и сохраняет новое превью. Для устаревшего превью источник
+// может подтвердить, что изображение не изменилось, тогда продлевается срок его актуальности.
+func (s *ImageService) fetch(
+	ctx context.Context,
+	imageID string,
+	imgParams *ImgParams,
+	headers http.Header,
+	cached *Preview,
+) (*Preview, error) {
+	var validators *storage.Metadata
+	if cached != nil {
+		validators = &cached.Meta
+	}
+	sourceImg, source, err := s.getImage(ctx, imgParams.URL, headers, validators)
+	if err != nil {
+		return nil, err
+	}
+	if source.notModified {
+		return s.revalidated(imageID, cached, source), nil
 	}
 
-	// Если изображение не найдено в кэше, загружаем его
-	sourceImg, err := s.getImage(imgParams.URL, r)
+	// Изменяем размер и кодируем в формате исходного изображения
+	resizedImg, err := s.resizeImage(ctx, sourceImg, imgParams.Width, imgParams.Height)
+	if err != nil {
+		return nil, err
+	}
+	data, contentType, err := encodeImage(resizedImg, source.format, s.quality)
 	if err != nil {
 		return nil, err
 	}
 
-	// Изменяем размер
-	resizedImg := resize.Resize(uint(imgParams.Width), uint(imgParams.Height), sourceImg, resize.Lanczos3)
+	preview := &Preview{
+		Data: data,
+		Meta: storage.Metadata{
+			SourceURL:            imgParams.URL,
+			Width:                imgParams.Width,
+			Height:               imgParams.Height,
+			ContentType:          contentType,
+			ETag:                 getETag(data),
+			UpstreamETag:         source.etag,
+			UpstreamLastModified: source.lastModified,
+			ValidatedAt:          time.Now(),
+		},
+		CacheStatus: CacheMiss,
+	}
 
 	// Кладем измененное изображение в кеш
-	s.cache.Set(lrucache.Key(imageID), resizedImg)
+	s.cache.Set(lrucache.Key(imageID), lrucache.Item{Data: preview.Data, Meta: preview.Meta})
 
 	// Записываем измененное изображение в хранилище
-	err = s.storage.Set(resizedImg, imageID)
+	err = s.saveToStorage(imageID, preview)
 	if err != nil {
 		return nil, err
 	}
 
-	return resizedImg, nil
+	return preview, nil
 }
 
-func (s *ImageService) getImage(imgURL string, r *http.Request) (image.Image, error) {
-	HTTPClient := client.NewHTTPClient(10 * time.Second)
+// lookup ищет превью сначала в кэше, затем в хранилище. Возвращает nil, если превью нет.
+func (s *ImageService) lookup(imageID string) *Preview {
+	// Проверяем наличие изображения в кэше
+	if cachedImg, ok := s.cache.Get(lrucache.Key(imageID)); ok {
+		fmt.Println("received from cache: ", imageID)
+		s.access.record(imageID)
+		return &Preview{Data: cachedImg.Data, Meta: completeMeta(cachedImg.Meta, cachedImg.Data)}
+	}
 
-	resp, err := HTTPClient.DoRequest("GET", imgURL, nil, r.Header)
+	// Если изображения нет в кэше, ищем его в хранилище
+	preview, err := s.loadFromStorage(imageID)
 	if err != nil {
-		fmt.Println(err.Error())
-		var dnsErr *net.DNSError
-		if errors.As(err, &dnsErr) {
-			return nil, ErrServerDoesNotExist
+		if !errors.Is(err, storage.ErrNotFound) {
+			s.logger.Error("read from storage: " + err.Error())
 		}
+		return nil
+	}
+	s.logger.Debug("received from storage: " + imageID)
+	s.access.record(imageID)
+	s.cache.Set(lrucache.Key(imageID), lrucache.Item{Data: preview.Data, Meta: preview.Meta})
+
+	return preview
+}
+
+// revalidated продлевает срок актуальности превью, которое источник подтвердил ответом 304.
+func (s *ImageService) revalidated(imageID string, cached *Preview, source *sourceInfo) *Preview {
+	meta := cached.Meta
+	meta.ValidatedAt = time.Now()
+	if source.etag != "" {
+		meta.UpstreamETag = source.etag
+	}
+	if source.lastModified != "" {
+		meta.UpstreamLastModified = source.lastModified
+	}
+
+	preview := &Preview{Data: cached.Data, Meta: meta, CacheStatus: CacheHit}
+	s.cache.Set(lrucache.Key(imageID), lrucache.Item{Data: preview.Data, Meta: preview.Meta})
+	if err := s.storage.UpdateMetadata(imageID, meta); err != nil {
+		s.logger.Error("update metadata in storage: " + err.Error())
+	}
+
+	return preview
+}
+
+// loadFromStorage читает сохраненное превью без декодирования.
+func (s *ImageService) loadFromStorage(imageID string) (*Preview, error) {
+	reader, meta, err := s.storage.Get(imageID)
+	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	defer reader.Close()
 
-	// Проверяем статус ответа
-	if resp.StatusCode == 404 {
-		return nil, ErrImageNotFound
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Preview{Data: data, Meta: completeMeta(meta, data)}, nil
+}
+
+// completeMeta восполняет метаданные превью, сохраненных без них предыдущими версиями
+// сервиса: тип содержимого определяется по данным, ETag вычисляется заново.
+func completeMeta(meta storage.Metadata, data []byte) storage.Metadata {
+	if meta.ContentType == "" {
+		meta.ContentType = http.DetectContentType(data)
+	}
+	if meta.ETag == "" {
+		meta.ETag = getETag(data)
+	}
+	return meta
+}
+
+// saveToStorage записывает в хранилище ровно те байты, которые отдаются клиенту.
+func (s *ImageService) saveToStorage(imageID string, preview *Preview) error {
+	writer, err := s.storage.Set(imageID, preview.Meta)
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(preview.Data); err != nil {
+		writer.Abort()
+		return err
+	}
+	return writer.Close()
+}
+
+// sourceInfo содержит валидаторы исходного изображения из ответа удаленного сервера.
+type sourceInfo struct {
+	format       string
+	etag         string
+	lastModified string
+	// notModified источник ответил 304, изображение не загружалось
+	notModified bool
+}
+
+// originResponse ответ источника изображения: удаленного сервера или локального файла.
+type originResponse struct {
+	body          io.ReadCloser
+	contentType   string
+	contentLength int64
+	maxSize       int64
+	etag          string
+	lastModified  string
+	// notModified источник подтвердил, что изображение не изменилось, тело отсутствует
+	notModified bool
+}
+
+// getImage загружает исходное изображение. Если переданы метаданные сохраненного превью,
+// запрос выполняется условным по валидаторам исходного изображения, и при ответе 304
+// изображение не возвращается, а в sourceInfo устанавливается notModified.
+func (s *ImageService) getImage(
+	ctx context.Context,
+	imgURL string,
+	headers http.Header,
+	validators *storage.Metadata,
+) (image.Image, *sourceInfo, error) {
+	originURL, err := url.Parse(imgURL)
+	if err != nil {
+		return nil, nil, ErrInvalidURL
 	}
 
+	var origin *originResponse
+	if originURL.Scheme == localScheme {
+		origin, err = s.local.open(originURL, int64(s.maxImageSize*1024), validators)
+	} else {
+		origin, err = s.openHTTP(ctx, originURL, headers, validators)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	source := &sourceInfo{
+		etag:         origin.etag,
+		lastModified: origin.lastModified,
+	}
+	if origin.notModified {
+		source.notModified = true
+		return nil, source, nil
+	}
+	defer origin.body.Close()
+
 	// Проверяем тип контента
-	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "image") {
-		return nil, ErrTargetNotImage
+	if !strings.HasPrefix(origin.contentType, "image") {
+		return nil, nil, ErrTargetNotImage
 	}
 
-	// Проверяем размер изображения
-	if resp.ContentLength > int64(s.maxImageSize*1024) {
-		return nil, ErrImageSize
+	// Проверяем размер изображения, если источник его сообщил
+	if origin.contentLength > origin.maxSize {
+		return nil, nil, ErrImageSize
 	}
 
 	fmt.Println("Downloaded from URL:", imgURL)
-	// Читаем изображение
-	sourceImg, _, err := image.Decode(resp.Body)
+	// Читаем изображение, ограничивая объем загружаемых данных независимо от Content-Length
+	body := newLimitedReader(origin.body, origin.maxSize)
+	sourceImg, format, err := decodeImage(body, s.maxPixels)
+	if err != nil {
+		if body.exceeded {
+			return nil, nil, ErrImageSize
+		}
+		return nil, nil, contextError(ctx, err)
+	}
+	source.format = format
+
+	return sourceImg, source, nil
+}
+
+// openHTTP выполняет запрос к удаленному серверу с учетом профиля источника.
+func (s *ImageService) openHTTP(
+	ctx context.Context,
+	originURL *url.URL,
+	headers http.Header,
+	validators *storage.Metadata,
+) (*originResponse, error) {
+	// Проверяем, разрешен ли источник, и применяем его профиль
+	profile, err := s.origins.resolve(originURL)
 	if err != nil {
 		return nil, err
 	}
+	headers = s.origins.headers(headers, profile)
+	if validators != nil {
+		if validators.UpstreamETag != "" {
+			headers.Set("If-None-Match", validators.UpstreamETag)
+		}
+		if validators.UpstreamLastModified != "" {
+			headers.Set("If-Modified-Since", validators.UpstreamLastModified)
+		}
+	}
+	maxSize := int64(s.maxImageSize * 1024)
+	cancel := context.CancelFunc(func() {})
+	if profile != nil {
+		if profile.Timeout > 0 {
+			// Таймаут действует до закрытия тела ответа
+			ctx, cancel = context.WithTimeout(ctx, profile.Timeout)
+		}
+		if profile.MaxSize > 0 {
+			maxSize = int64(profile.MaxSize * 1024)
+		}
+	}
+
+	opts := []client.RequestOption{
+		client.WithRetry(s.origins.retryPolicy(profile)),
+		client.WithRedirectCheck(s.checkRedirect),
+	}
+	if profile != nil {
+		opts = append(opts, client.WithRateLimit(profile.RateLimit))
+	}
+	resp, err := s.client.DoRequest(ctx, "GET", originURL.String(), nil, headers, opts...)
+	if err != nil {
+		cancel()
+		fmt.Println(err.Error())
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return nil, ErrServerDoesNotExist
+		}
+		return nil, contextError(ctx, err)
+	}
+
+	if chain := client.RedirectChain(resp); len(chain) > 1 {
+		s.logger.Info("origin redirected: " + strings.Join(chain, " -> "))
+	}
+
+	origin := &originResponse{
+		body:          client.CancelOnClose(resp.Body, cancel),
+		contentType:   resp.Header.Get("Content-Type"),
+		contentLength: resp.ContentLength,
+		maxSize:       maxSize,
+		etag:          resp.Header.Get("ETag"),
+		lastModified:  resp.Header.Get("Last-Modified"),
+	}
+
+	// Проверяем статус ответа
+	if resp.StatusCode == http.StatusNotModified && validators != nil {
+		origin.body.Close()
+		origin.notModified = true
+		return origin, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		origin.body.Close()
+		return nil, &UpstreamStatusError{StatusCode: resp.StatusCode}
+	}
+
+	return origin, nil
+}
 
-	return sourceImg, nil
+// checkRedirect применяет к адресу перехода те же ограничения, что и к исходному адресу.
+func (s *ImageService) checkRedirect(u *url.URL) error {
+	_, err := s.origins.resolve(u)
+	return err
 }
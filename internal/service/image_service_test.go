@@ -0,0 +1,304 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	lrucache "github.com/Lanworm/image-previewer/internal/cache"
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/Lanworm/image-previewer/internal/http/client"
+	"github.com/Lanworm/image-previewer/internal/logger"
+	"github.com/Lanworm/image-previewer/internal/storage"
+	"github.com/Lanworm/image-previewer/internal/storage/memorystorage"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T) *ImageService {
+	t.Helper()
+
+	return newTestServiceWithOrigins(t, config.OriginsConf{})
+}
+
+func newTestServiceWithOrigins(t *testing.T, origins config.OriginsConf) *ImageService {
+	t.Helper()
+
+	return newTestServiceWithStorage(t, memorystorage.NewMemoryStorage(), origins)
+}
+
+func newTestServiceWithStorage(t *testing.T, store storage.Storage, origins config.OriginsConf) *ImageService {
+	t.Helper()
+
+	logg, err := logger.New("ERROR", io.Discard)
+	require.NoError(t, err)
+	httpClient, err := client.NewHTTPClient(config.ClientConf{AllowCIDRs: []string{"127.0.0.0/8"}})
+	require.NoError(t, err)
+
+	return NewImageService(
+		logg,
+		store,
+		lrucache.NewCache(10),
+		httpClient,
+		config.ServiceConf{Size: 64},
+		origins,
+	)
+}
+
+// Зависший удаленный сервер, отвечающий только после отмены запроса.
+func newHangingOrigin(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Удаленный сервер, отдающий изображение частями без заголовка Content-Length.
+func newChunkedOrigin(t *testing.T, data []byte) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		for start := 0; start < len(data); start += 512 {
+			w.Write(data[start:min(start+512, len(data))])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// noiseJPEG создает JPEG с шумом, который плохо сжимается и занимает заметный объем.
+func noiseJPEG(t *testing.T, size int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	rnd := rand.New(rand.NewSource(1))
+	rnd.Read(img.Pix)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, jpeg.Encode(buf, img, &jpeg.Options{Quality: 100}))
+	return buf.Bytes()
+}
+
+func TestResizeImgChunked(t *testing.T) {
+	s := newTestService(t)
+	origin := newChunkedOrigin(t, noiseJPEG(t, 32))
+
+	preview, err := s.ResizeImg(context.Background(), &ImgParams{Width: 10, Height: 5, URL: origin.URL + "/image.jpg"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "image/jpeg", preview.Meta.ContentType)
+
+	img, err := jpeg.Decode(bytes.NewReader(preview.Data))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 10, 5), img.Bounds())
+}
+
+// Ограничение размера срабатывает при чтении, даже если сервер не сообщил Content-Length.
+func TestResizeImgChunkedTooLarge(t *testing.T) {
+	s := newTestService(t)
+	data := noiseJPEG(t, 256)
+	require.Greater(t, len(data), s.maxImageSize*1024)
+	origin := newChunkedOrigin(t, data)
+
+	_, err := s.ResizeImg(context.Background(), &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/image.jpg"}, nil)
+	require.ErrorIs(t, err, ErrImageSize)
+}
+
+// Маленький PNG, в заголовке которого заявлено огромное разрешение, отклоняется до декодирования.
+func TestResizeImgDecompressionBomb(t *testing.T) {
+	s := newTestService(t)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, png.Encode(buf, image.NewGray(image.Rect(0, 0, 1, 1))))
+	data := buf.Bytes()
+
+	// Подменяем ширину и высоту в чанке IHDR и пересчитываем его контрольную сумму
+	binary.BigEndian.PutUint32(data[16:20], 50000)
+	binary.BigEndian.PutUint32(data[20:24], 50000)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	defer origin.Close()
+
+	_, err := s.ResizeImg(context.Background(), &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/bomb.png"}, nil)
+	require.ErrorIs(t, err, ErrImageDimensions)
+}
+
+func TestLimitedReader(t *testing.T) {
+	r := newLimitedReader(strings.NewReader("0123456789"), 5)
+	data, err := io.ReadAll(r)
+	require.ErrorIs(t, err, ErrImageSize)
+	require.Equal(t, "01234", string(data))
+
+	r = newLimitedReader(strings.NewReader("01234"), 5)
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "01234", string(data))
+}
+
+func TestResizeImgCanceled(t *testing.T) {
+	s := newTestService(t)
+	origin := newHangingOrigin(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := s.ResizeImg(ctx, &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/image.jpg"}, nil)
+	require.ErrorIs(t, err, ErrRequestCanceled)
+}
+
+func TestResizeImageSlots(t *testing.T) {
+	s := newTestService(t)
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+
+	resized, err := s.resizeImage(context.Background(), img, 10, 10)
+	require.NoError(t, err)
+	require.Equal(t, 10, resized.Bounds().Dx())
+
+	// Все слоты заняты: запрос ждет освобождения и завершается при отмене
+	for i := 0; i < cap(s.resizeSlots); i++ {
+		s.resizeSlots <- struct{}{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err = s.resizeImage(ctx, img, 10, 10)
+	require.ErrorIs(t, err, ErrRequestCanceled)
+	require.Len(t, s.resizeSlots, cap(s.resizeSlots))
+}
+
+func TestResizeImgTimeout(t *testing.T) {
+	s := newTestService(t)
+	origin := newHangingOrigin(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := s.ResizeImg(ctx, &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/image.jpg"}, nil)
+	require.ErrorIs(t, err, ErrOriginTimeout)
+}
+
+// Любой ответ, отличный от 2xx, возвращается как ошибка с кодом ответа удаленного сервера.
+func TestResizeImgUpstreamStatus(t *testing.T) {
+	s := newTestService(t)
+
+	for _, status := range []int{http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError} {
+		origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.WriteHeader(status)
+			w.Write(noiseJPEG(t, 8))
+		}))
+
+		_, err := s.ResizeImg(context.Background(), &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/image.jpg"}, nil)
+		origin.Close()
+
+		var upstreamErr *UpstreamStatusError
+		require.ErrorAs(t, err, &upstreamErr)
+		require.Equal(t, status, upstreamErr.StatusCode)
+		require.ErrorIs(t, err, ErrUpstreamStatus)
+		require.Equal(t, status == http.StatusNotFound, errors.Is(err, ErrImageNotFound))
+	}
+}
+
+// Переход по редиректу на запрещенный хост прерывает загрузку.
+func TestResizeImgRedirectToDisallowedHost(t *testing.T) {
+	s := newTestServiceWithOrigins(t, config.OriginsConf{AllowedHosts: []string{"127.0.0.1"}})
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://localhost/image.jpg", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	_, err := s.ResizeImg(context.Background(), &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/image.jpg"}, nil)
+	require.ErrorIs(t, err, ErrOriginNotAllowed)
+}
+
+// Устаревшее превью проверяется условным запросом, ответ 304 продлевает его без повторной загрузки.
+func TestResizeImgRevalidation(t *testing.T) {
+	s := newTestService(t)
+	s.ttl = 50 * time.Millisecond
+	data := noiseJPEG(t, 16)
+
+	var downloads, revalidations int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&revalidations, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&downloads, 1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(data)
+	}))
+	defer origin.Close()
+
+	params := &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/image.jpg"}
+	first, err := s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+
+	// Пока превью актуально, источник не запрашивается
+	_, err = s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+	require.Equal(t, int32(0), atomic.LoadInt32(&revalidations))
+
+	time.Sleep(60 * time.Millisecond)
+	second, err := s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+	require.Equal(t, int32(1), atomic.LoadInt32(&revalidations))
+	require.Equal(t, first.Data, second.Data)
+	require.True(t, second.Meta.ValidatedAt.After(first.Meta.ValidatedAt))
+
+	// Новый срок сохранен в хранилище
+	imageID := getURLHash("resize" + "10" + "10" + params.URL)
+	stored, err := s.storage.GetMetadata(imageID)
+	require.NoError(t, err)
+	require.True(t, stored.ValidatedAt.Equal(second.Meta.ValidatedAt))
+
+	// После продления превью снова отдается без обращения к источнику
+	_, err = s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&revalidations))
+}
+
+// Превью, сохраненные без метаданных, отдаются с типом содержимого и ETag.
+func TestResizeImgLegacyPreview(t *testing.T) {
+	store := memorystorage.NewMemoryStorage()
+	s := newTestServiceWithStorage(t, store, config.OriginsConf{})
+	params := &ImgParams{Width: 10, Height: 10, URL: "https://cdn.example.com/a.jpg"}
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 10, 10)), nil))
+	w, err := store.Set(getURLHash("resize1010"+params.URL), storage.Metadata{})
+	require.NoError(t, err)
+	_, err = w.Write(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	preview, err := s.ResizeImg(context.Background(), params, nil)
+	require.NoError(t, err)
+	require.Equal(t, CacheHit, preview.CacheStatus)
+	require.Equal(t, "image/jpeg", preview.Meta.ContentType)
+	require.Equal(t, getETag(buf.Bytes()), preview.Meta.ETag)
+}
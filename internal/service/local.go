@@ -0,0 +1,120 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/Lanworm/image-previewer/internal/storage"
+)
+
+// localScheme схема адресов изображений из локальных директорий: file://{alias}/путь.
+const localScheme = "file"
+
+var ErrLocalPathForbidden = errors.New("path is outside of the local origin root")
+
+// localOrigins локальные директории, из которых загружаются изображения.
+type localOrigins struct {
+	roots map[string]string
+}
+
+func newLocalOrigins(roots []config.LocalRoot) *localOrigins {
+	l := &localOrigins{roots: make(map[string]string, len(roots))}
+	for _, root := range roots {
+		dir, err := filepath.Abs(root.Path)
+		if err != nil {
+			dir = root.Path
+		}
+		l.roots[root.Alias] = dir
+	}
+	return l
+}
+
+// open открывает файл по адресу file://{alias}/путь. Для файла, не изменившегося
+// с момента, описанного validators, возвращается ответ с notModified.
+func (l *localOrigins) open(u *url.URL, maxSize int64, validators *storage.Metadata) (*originResponse, error) {
+	filePath, err := l.resolve(u.Host, u.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, ErrTargetNotImage
+	}
+
+	origin := &originResponse{
+		contentLength: info.Size(),
+		maxSize:       maxSize,
+		etag:          fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()),
+		lastModified:  info.ModTime().UTC().Format(http.TimeFormat),
+	}
+	if validators != nil && validators.UpstreamETag == origin.etag {
+		file.Close()
+		origin.notModified = true
+		return origin, nil
+	}
+
+	// Тип содержимого определяем по первым байтам файла, а не по расширению
+	reader := bufio.NewReader(file)
+	head, _ := reader.Peek(512)
+	origin.contentType = http.DetectContentType(head)
+	origin.body = &fileReadCloser{Reader: reader, file: file}
+
+	return origin, nil
+}
+
+// resolve возвращает путь к файлу внутри корневой директории псевдонима. Символические
+// ссылки раскрываются, и итоговый путь должен оставаться внутри корня.
+func (l *localOrigins) resolve(alias, urlPath string) (string, error) {
+	root, ok := l.roots[alias]
+	if !ok {
+		return "", ErrOriginNotAllowed
+	}
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("local origin %q: %w", alias, err)
+	}
+
+	// Очистка относительно корня "/" отбрасывает все переходы ".." за его пределы
+	full := filepath.Join(root, filepath.FromSlash(path.Clean("/"+urlPath)))
+	full, err = filepath.EvalSymlinks(full)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", ErrImageNotFound
+		}
+		return "", err
+	}
+
+	if full != root && !strings.HasPrefix(full, root+string(filepath.Separator)) {
+		return "", ErrLocalPathForbidden
+	}
+	return full, nil
+}
+
+type fileReadCloser struct {
+	io.Reader
+	file *os.File
+}
+
+func (f *fileReadCloser) Close() error {
+	return f.file.Close()
+}
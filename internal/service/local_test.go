@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/Lanworm/image-previewer/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// newLocalRoot создает корневую директорию с изображением и файлом рядом с ней, вне корня.
+func newLocalRoot(t *testing.T) (string, string) {
+	t.Helper()
+
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "nested"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "nested", "image.jpg"), noiseJPEG(t, 16), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "notes.txt"), []byte("just text"), 0o600))
+
+	secret := filepath.Join(base, "secret.jpg")
+	require.NoError(t, os.WriteFile(secret, noiseJPEG(t, 16), 0o600))
+	return root, secret
+}
+
+func TestLocalOriginsResolve(t *testing.T) {
+	root, secret := newLocalRoot(t)
+	require.NoError(t, os.Symlink(secret, filepath.Join(root, "escape.jpg")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "nested", "image.jpg"), filepath.Join(root, "inside.jpg")))
+	l := newLocalOrigins([]config.LocalRoot{{Alias: "assets", Path: root}})
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	require.NoError(t, err)
+	expected := filepath.Join(realRoot, "nested", "image.jpg")
+
+	for _, p := range []string{"/nested/image.jpg", "nested/./image.jpg", "/nested/../nested/image.jpg", "/inside.jpg"} {
+		resolved, err := l.resolve("assets", p)
+		require.NoError(t, err, p)
+		require.Equal(t, expected, resolved, p)
+	}
+
+	// Переходы за пределы корня не выходят за его границу
+	_, err = l.resolve("assets", "/../secret.jpg")
+	require.ErrorIs(t, err, ErrImageNotFound)
+	_, err = l.resolve("assets", "/escape.jpg")
+	require.ErrorIs(t, err, ErrLocalPathForbidden)
+
+	_, err = l.resolve("unknown", "/nested/image.jpg")
+	require.ErrorIs(t, err, ErrOriginNotAllowed)
+}
+
+func TestLocalOriginsOpen(t *testing.T) {
+	root, _ := newLocalRoot(t)
+	l := newLocalOrigins([]config.LocalRoot{{Alias: "assets", Path: root}})
+
+	origin, err := l.open(&url.URL{Scheme: localScheme, Host: "assets", Path: "/nested/image.jpg"}, 1<<20, nil)
+	require.NoError(t, err)
+	origin.body.Close()
+	require.Equal(t, "image/jpeg", origin.contentType)
+	require.NotEmpty(t, origin.etag)
+
+	// Неизменившийся файл не открывается повторно
+	again, err := l.open(&url.URL{Scheme: localScheme, Host: "assets", Path: "/nested/image.jpg"}, 1<<20,
+		&storage.Metadata{UpstreamETag: origin.etag})
+	require.NoError(t, err)
+	require.True(t, again.notModified)
+
+	_, err = l.open(&url.URL{Scheme: localScheme, Host: "assets", Path: "/nested"}, 1<<20, nil)
+	require.ErrorIs(t, err, ErrTargetNotImage)
+}
+
+func TestResizeImgLocal(t *testing.T) {
+	root, _ := newLocalRoot(t)
+	s := newTestServiceWithOrigins(t, config.OriginsConf{
+		Local: []config.LocalRoot{{Alias: "assets", Path: root}},
+	})
+
+	preview, err := s.ResizeImg(context.Background(), &ImgParams{Width: 10, Height: 5, URL: "file://assets/nested/image.jpg"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "image/jpeg", preview.Meta.ContentType)
+
+	// Текстовый файл проходит ту же проверку формата, что и ответ удаленного сервера
+	_, err = s.ResizeImg(context.Background(), &ImgParams{Width: 10, Height: 5, URL: "file://assets/notes.txt"}, nil)
+	require.ErrorIs(t, err, ErrTargetNotImage)
+
+	_, err = s.ResizeImg(context.Background(), &ImgParams{Width: 10, Height: 5, URL: "file://assets/missing.jpg"}, nil)
+	require.ErrorIs(t, err, ErrImageNotFound)
+}
+
+func TestPrepareImgParamsLocal(t *testing.T) {
+	s := newTestService(t)
+	for _, path := range []string{"local/assets/nested/image.jpg", "file:/assets/nested/image.jpg"} {
+		params, err := prepareFromPath(t, s, "/fill/10/5/"+path)
+		require.NoError(t, err, path)
+		require.Equal(t, "file://assets/nested/image.jpg", params.URL, path)
+	}
+}
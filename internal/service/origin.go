@@ -0,0 +1,145 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+)
+
+var (
+	ErrOriginNotAllowed = errors.New("origin host is not allowed")
+	ErrHTTPSRequired    = errors.New("origin requires https")
+	ErrUnknownAlias     = errors.New("unknown origin alias")
+)
+
+// originPolicy определяет, разрешен ли источник, и какие настройки к нему применяются.
+type originPolicy struct {
+	allowedHosts   []string
+	profiles       []config.OriginProfile
+	forwardHeaders []string
+	staticHeaders  map[string]string
+	retry          config.RetryConf
+	aliases        map[string]string
+	defaultScheme  string
+}
+
+func newOriginPolicy(conf config.OriginsConf) *originPolicy {
+	defaultScheme := conf.DefaultScheme
+	if defaultScheme == "" {
+		defaultScheme = "https"
+	}
+
+	return &originPolicy{
+		allowedHosts:   conf.AllowedHosts,
+		profiles:       conf.Profiles,
+		forwardHeaders: conf.ForwardHeaders,
+		staticHeaders:  conf.Headers,
+		retry:          conf.Retry,
+		aliases:        conf.Aliases,
+		defaultScheme:  defaultScheme,
+	}
+}
+
+// resolve проверяет источник и возвращает первый подходящий профиль или nil.
+func (p *originPolicy) resolve(u *url.URL) (*config.OriginProfile, error) {
+	host := u.Hostname()
+
+	if len(p.allowedHosts) > 0 && !matchAnyHost(p.allowedHosts, host) {
+		return nil, ErrOriginNotAllowed
+	}
+
+	for i := range p.profiles {
+		profile := &p.profiles[i]
+		if !matchHost(profile.Host, host) {
+			continue
+		}
+		if profile.RequireHTTPS && u.Scheme != "https" {
+			return nil, ErrHTTPSRequired
+		}
+		return profile, nil
+	}
+
+	return nil, nil
+}
+
+// expandAlias заменяет псевдоним в начале пути alias/путь на базовый адрес источника.
+func (p *originPolicy) expandAlias(aliasPath string) (string, error) {
+	alias, rest, _ := strings.Cut(aliasPath, "/")
+
+	baseURL, ok := p.aliases[alias]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownAlias, alias)
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + rest, nil
+}
+
+func matchAnyHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if matchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost сравнивает хост с шаблоном без учета регистра.
+// Шаблон *.example.com подходит для поддоменов, но не для самого example.com.
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(host)
+
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
+
+// headers собирает заголовки запроса к источнику. Из запроса клиента передаются только
+// явно разрешенные заголовки, чтобы не передавать третьим лицам cookies и учетные данные.
+// Статические заголовки и заголовки профиля добавляются поверх них.
+func (p *originPolicy) headers(incoming http.Header, profile *config.OriginProfile) http.Header {
+	result := make(http.Header)
+
+	for _, name := range p.forwardHeaders {
+		for _, value := range incoming.Values(name) {
+			result.Add(name, value)
+		}
+	}
+	for name, value := range p.staticHeaders {
+		result.Set(name, value)
+	}
+	if profile != nil {
+		for name, value := range profile.Headers {
+			result.Set(name, value)
+		}
+	}
+
+	return result
+}
+
+// retryPolicy возвращает общую политику повторов, в которой заданные в профиле параметры
+// заменяют общие. Профиль может задать, например, только deadline.
+func (p *originPolicy) retryPolicy(profile *config.OriginProfile) config.RetryConf {
+	retry := p.retry
+	if profile == nil {
+		return retry
+	}
+
+	if profile.Retry.MaxAttempts > 0 {
+		retry.MaxAttempts = profile.Retry.MaxAttempts
+	}
+	if profile.Retry.BaseDelay > 0 {
+		retry.BaseDelay = profile.Retry.BaseDelay
+	}
+	if profile.Retry.MaxDelay > 0 {
+		retry.MaxDelay = profile.Retry.MaxDelay
+	}
+	if profile.Retry.Deadline > 0 {
+		retry.Deadline = profile.Retry.Deadline
+	}
+	return retry
+}
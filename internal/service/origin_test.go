@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		match   bool
+	}{
+		{pattern: "cdn.example.com", host: "cdn.example.com", match: true},
+		{pattern: "cdn.example.com", host: "CDN.Example.com", match: true},
+		{pattern: "cdn.example.com", host: "img.example.com", match: false},
+		{pattern: "*.example.com", host: "img.example.com", match: true},
+		{pattern: "*.example.com", host: "a.b.example.com", match: true},
+		{pattern: "*.example.com", host: "example.com", match: false},
+		{pattern: "*.example.com", host: "badexample.com", match: false},
+	}
+
+	for _, tc := range tests {
+		require.Equal(t, tc.match, matchHost(tc.pattern, tc.host), "%s ~ %s", tc.pattern, tc.host)
+	}
+}
+
+func TestOriginPolicy(t *testing.T) {
+	policy := newOriginPolicy(config.OriginsConf{
+		AllowedHosts: []string{"*.example.com"},
+		Profiles: []config.OriginProfile{
+			{Host: "secure.example.com", RequireHTTPS: true},
+			{Host: "*.example.com", MaxSize: 10},
+		},
+	})
+
+	_, err := policy.resolve(mustParseURL(t, "http://evil.com/image.jpg"))
+	require.ErrorIs(t, err, ErrOriginNotAllowed)
+
+	_, err = policy.resolve(mustParseURL(t, "http://secure.example.com/image.jpg"))
+	require.ErrorIs(t, err, ErrHTTPSRequired)
+
+	profile, err := policy.resolve(mustParseURL(t, "https://secure.example.com/image.jpg"))
+	require.NoError(t, err)
+	require.True(t, profile.RequireHTTPS)
+
+	profile, err = policy.resolve(mustParseURL(t, "http://cdn.example.com:8080/image.jpg"))
+	require.NoError(t, err)
+	require.Equal(t, 10, profile.MaxSize)
+}
+
+// Параметры повторов, не заданные в профиле, берутся из общей политики.
+func TestOriginRetryPolicy(t *testing.T) {
+	policy := newOriginPolicy(config.OriginsConf{
+		Retry: config.RetryConf{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second, Deadline: 5 * time.Second},
+	})
+
+	require.Equal(t, policy.retry, policy.retryPolicy(nil))
+	require.Equal(t, policy.retry, policy.retryPolicy(&config.OriginProfile{}))
+	require.Equal(t,
+		config.RetryConf{MaxAttempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second, Deadline: 30 * time.Second},
+		policy.retryPolicy(&config.OriginProfile{Retry: config.RetryConf{Deadline: 30 * time.Second}}))
+	require.Equal(t,
+		config.RetryConf{MaxAttempts: 1, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Deadline: 5 * time.Second},
+		policy.retryPolicy(&config.OriginProfile{Retry: config.RetryConf{MaxAttempts: 1, MaxDelay: time.Second}}))
+}
+
+func TestOriginHeaders(t *testing.T) {
+	policy := newOriginPolicy(config.OriginsConf{
+		ForwardHeaders: []string{"Accept", "X-Request-Id"},
+		Headers:        map[string]string{"X-Previewer": "1", "Accept": "image/*"},
+	})
+
+	incoming := http.Header{
+		"Accept":        {"image/webp"},
+		"X-Request-Id":  {"42"},
+		"Cookie":        {"session=secret"},
+		"Authorization": {"Basic dXNlcjpwYXNz"},
+	}
+	headers := policy.headers(incoming, &config.OriginProfile{Headers: map[string]string{"X-Previewer": "2"}})
+
+	require.Equal(t, http.Header{
+		"Accept":       {"image/*"},
+		"X-Request-Id": {"42"},
+		"X-Previewer":  {"2"},
+	}, headers)
+}
+
+// Заголовки профиля передаются источнику вместе с запросом.
+func TestResizeImgProfileHeaders(t *testing.T) {
+	var token, cookie, userAgent string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("Authorization")
+		cookie = r.Header.Get("Cookie")
+		userAgent = r.Header.Get("User-Agent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer origin.Close()
+
+	s := newTestServiceWithOrigins(t, config.OriginsConf{
+		AllowedHosts: []string{"127.0.0.1"},
+		Profiles: []config.OriginProfile{
+			{Host: "127.0.0.1", Headers: map[string]string{"Authorization": "Bearer secret"}},
+		},
+	})
+
+	incoming := http.Header{"Cookie": {"session=secret"}, "User-Agent": {"browser"}}
+	_, err := s.ResizeImg(context.Background(), &ImgParams{Width: 10, Height: 10, URL: origin.URL + "/image.jpg"}, incoming)
+	require.ErrorIs(t, err, ErrImageNotFound)
+	require.Equal(t, "Bearer secret", token)
+	require.Empty(t, cookie)
+	require.Equal(t, "image-previewer", userAgent)
+}
+
+func TestPrepareImgParamsAlias(t *testing.T) {
+	s := newTestServiceWithOrigins(t, config.OriginsConf{
+		Aliases: map[string]string{"assets": "https://cdn.example.com/static/"},
+	})
+
+	params, err := prepareFromPath(t, s, "/fill/300/200/@assets/2024/img.jpg")
+	require.NoError(t, err)
+	require.Equal(t, "https://cdn.example.com/static/2024/img.jpg", params.URL)
+
+	// Неизвестный псевдоним не превращается в обычный адрес
+	_, err = prepareFromPath(t, s, "/fill/300/200/@media/img.jpg")
+	require.ErrorIs(t, err, ErrUnknownAlias)
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u
+}
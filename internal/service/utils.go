@@ -1,9 +1,14 @@
 package service
 
 import (
+	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
+	"image"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -11,6 +16,7 @@ import (
 
 	"github.com/Lanworm/image-previewer/internal/validation"
 	"github.com/gorilla/mux"
+	"github.com/nfnt/resize"
 )
 
 var (
@@ -19,23 +25,33 @@ var (
 	ErrInvalidURL                         = errors.New("invalid URL")
 )
 
-func PrepareImgParams(r *http.Request) (imgParams *ImgParams, err error) {
+// encodedURLPrefix префикс сегмента пути с адресом изображения в URL-safe base64.
+const encodedURLPrefix = "b64/"
+
+// PrepareImgParams разбирает параметры превью из пути запроса. Адрес изображения
+// может быть задан полностью, в base64 (b64/{base64}), через псевдоним источника
+// (@alias/путь) или как путь в локальной директории (local/{alias}/путь).
+//
+// Маршрутизатор передает путь без декодирования, поэтому адрес, закодированный
+// целиком (https%3A%2F%2F...), сохраняет схему, параметры запроса и фрагмент.
+// Параметры запроса к сервису передаются источнику вместе с адресом.
+func (s *ImageService) PrepareImgParams(r *http.Request) (imgParams *ImgParams, err error) {
 	vars := mux.Vars(r)
 	width := vars["width"]
 	height := vars["height"]
-	imageURL := vars["url"]
-
-	// Удаляем "https/" из URL, если присутствует
-	imageURL = strings.ReplaceAll(imageURL, "http:/", "")
-	imageURL = strings.ReplaceAll(imageURL, "https:/", "")
 
-	// Добавляем 'https://' в URL, если отсутствует
-	if !strings.HasPrefix(imageURL, "http://") && !strings.HasPrefix(imageURL, "https://") {
-		imageURL = "http://" + imageURL
+	var imageURL string
+	if encoded, ok := strings.CutPrefix(vars["url"], encodedURLPrefix); ok {
+		imageURL, err = decodeSourceURL(encoded)
+	} else {
+		imageURL, err = s.expandSourcePath(vars["url"])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r.URL.RawQuery != "" && !strings.HasPrefix(imageURL, localScheme+"://") {
+		imageURL = appendQuery(imageURL, r.URL.RawQuery)
 	}
-
-	// Удаляем лишний символ '/' в конце URL изображения
-	imageURL = strings.TrimSuffix(imageURL, "/")
 
 	// Проверяем валидность URl
 	_, err = url.ParseRequestURI(imageURL)
@@ -50,6 +66,77 @@ func PrepareImgParams(r *http.Request) (imgParams *ImgParams, err error) {
 	return params, nil
 }
 
+// decodeSourceURL декодирует адрес изображения из URL-safe base64, допускается
+// и вариант без выравнивания "=". Адрес используется без изменений и должен быть полным.
+func decodeSourceURL(encoded string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return "", ErrInvalidURL
+	}
+
+	u, err := url.Parse(string(data))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", ErrInvalidURL
+	}
+	return string(data), nil
+}
+
+// expandSourcePath приводит адрес изображения из пути к полному адресу. Адрес, закодированный
+// целиком, декодируется, в остальных формах путь передается источнику закодированным как есть.
+func (s *ImageService) expandSourcePath(rawPath string) (string, error) {
+	imageURL := rawPath
+	if first, _, _ := strings.Cut(rawPath, "/"); strings.Contains(strings.ToLower(first), "%3a") {
+		decoded, err := url.PathUnescape(rawPath)
+		if err != nil {
+			return "", ErrInvalidURL
+		}
+		imageURL = decoded
+	}
+
+	switch {
+	case strings.HasPrefix(imageURL, "@"):
+		// Псевдоним источника: @alias/путь
+		return s.origins.expandAlias(strings.TrimPrefix(imageURL, "@"))
+	case strings.HasPrefix(imageURL, "local/"):
+		// Изображение из локальной директории: local/{alias}/путь
+		return localScheme + "://" + strings.TrimPrefix(imageURL, "local/"), nil
+	case strings.HasPrefix(imageURL, localScheme+":/"):
+		// Маршрутизатор схлопывает "//" в пути, поэтому file://{alias} приходит как file:/{alias}
+		return localScheme + "://" + strings.TrimLeft(strings.TrimPrefix(imageURL, localScheme+":"), "/"), nil
+	case strings.HasPrefix(imageURL, "http://"), strings.HasPrefix(imageURL, "https://"):
+		// Адрес был закодирован целиком и используется без изменений
+		return imageURL, nil
+	}
+
+	// Схема в пути приходит как "http:/", ее восстанавливаем, остальной адрес не меняем
+	scheme := s.origins.defaultScheme
+	for _, prefix := range []string{"http", "https"} {
+		if rest, ok := strings.CutPrefix(imageURL, prefix+":/"); ok {
+			scheme, imageURL = prefix, rest
+			break
+		}
+	}
+
+	// Удаляем лишний символ '/' в конце URL изображения
+	return scheme + "://" + strings.TrimSuffix(imageURL, "/"), nil
+}
+
+// appendQuery добавляет параметры запроса к адресу перед фрагментом, сохраняя уже имеющиеся.
+func appendQuery(imageURL, rawQuery string) string {
+	base, fragment, hasFragment := strings.Cut(imageURL, "#")
+
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	base += separator + rawQuery
+
+	if hasFragment {
+		return base + "#" + fragment
+	}
+	return base
+}
+
 func NewImgParams(width string, height string, url string) (*ImgParams, error) {
 	w, errw := strconv.Atoi(width)
 	h, errh := strconv.Atoi(height)
@@ -70,6 +157,80 @@ func NewImgParams(width string, height string, url string) (*ImgParams, error) {
 	return p, nil
 }
 
+// resizeImage изменяет размер изображения, прекращая ожидание при отмене контекста.
+// Начатое изменение размера прервать нельзя, поэтому количество одновременных операций
+// ограничено: операция, брошенная после отмены запроса, занимает слот до своего завершения,
+// и новые запросы ждут его освобождения, не нагружая процессор сверх лимита.
+func (s *ImageService) resizeImage(ctx context.Context, img image.Image, width, height int) (image.Image, error) {
+	select {
+	case s.resizeSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, contextError(ctx, ctx.Err())
+	}
+	if err := ctx.Err(); err != nil {
+		<-s.resizeSlots
+		return nil, contextError(ctx, err)
+	}
+
+	done := make(chan image.Image, 1)
+	go func() {
+		defer func() { <-s.resizeSlots }()
+		done <- resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
+	}()
+
+	select {
+	case resized := <-done:
+		return resized, nil
+	case <-ctx.Done():
+		return nil, contextError(ctx, ctx.Err())
+	}
+}
+
+// contextError приводит ошибки отмены запроса и превышения времени ожидания к ошибкам сервиса.
+func contextError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ErrRequestCanceled
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrOriginTimeout
+	}
+	return err
+}
+
+// limitedReader читает не больше limit байт и возвращает ErrImageSize при превышении лимита.
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func newLimitedReader(reader io.Reader, limit int64) *limitedReader {
+	return &limitedReader{reader: reader, remaining: limit}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, ErrImageSize
+	}
+
+	// Читаем на один байт больше остатка, чтобы обнаружить превышение лимита
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.reader.Read(p)
+	if int64(n) > l.remaining {
+		l.exceeded = true
+		n = int(l.remaining)
+		l.remaining = 0
+		return n, ErrImageSize
+	}
+	l.remaining -= int64(n)
+
+	return n, err
+}
+
 func getURLHash(url string) string {
 	hasher := sha256.New()
 	hasher.Write([]byte(url))
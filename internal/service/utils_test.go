@@ -0,0 +1,165 @@
+package service
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+// prepareFromPath разбирает параметры превью так же, как это происходит за маршрутизатором сервера.
+func prepareFromPath(t *testing.T, s *ImageService, target string) (*ImgParams, error) {
+	t.Helper()
+
+	var (
+		params *ImgParams
+		err    error
+	)
+	router := mux.NewRouter().UseEncodedPath()
+	router.HandleFunc("/fill/{width}/{height}/{url:.*}", func(_ http.ResponseWriter, r *http.Request) {
+		params, err = s.PrepareImgParams(r)
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	require.Equal(t, http.StatusOK, rec.Code, target)
+	return params, err
+}
+
+func TestPrepareImgParamsEncoded(t *testing.T) {
+	s := newTestService(t)
+	source := "https://cdn.example.com/img/http://mirror/a.jpg?size=large&v=2#top"
+
+	tests := []struct {
+		name string
+		path string
+		url  string
+	}{
+		{
+			name: "base64",
+			path: "b64/" + base64.RawURLEncoding.EncodeToString([]byte(source)),
+			url:  source,
+		},
+		{
+			name: "base64 with padding",
+			path: "b64/" + base64.URLEncoding.EncodeToString([]byte("https://cdn.example.com/a.jpg")),
+			url:  "https://cdn.example.com/a.jpg",
+		},
+		{
+			name: "percent-encoded",
+			path: "https%3A%2F%2Fcdn.example.com%2Fimg%2Fa.jpg%3Fsize%3Dlarge%26v%3D2%23top",
+			url:  "https://cdn.example.com/img/a.jpg?size=large&v=2#top",
+		},
+		{
+			// Маршрутизатор схлопывает "//" в пути, "https:/" внутри адреса не меняется
+			name: "plain https",
+			path: "https:/cdn.example.com/img/https:/a.jpg",
+			url:  "https://cdn.example.com/img/https:/a.jpg",
+		},
+		{
+			name: "plain without scheme",
+			path: "cdn.example.com/img/a.jpg/",
+			url:  "https://cdn.example.com/img/a.jpg",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			params, err := prepareFromPath(t, s, "/fill/300/200/"+tc.path)
+			require.NoError(t, err)
+			require.Equal(t, tc.url, params.URL)
+		})
+	}
+
+	// Закодированный адрес должен быть полным и корректным
+	for _, path := range []string{"b64/not*base64", "b64/" + base64.RawURLEncoding.EncodeToString([]byte("/a.jpg"))} {
+		_, err := prepareFromPath(t, s, "/fill/300/200/"+path)
+		require.ErrorIs(t, err, ErrInvalidURL, path)
+	}
+}
+
+func TestPrepareImgParamsSourceURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme string
+		target string
+		url    string
+	}{
+		{
+			name:   "query",
+			target: "/fill/300/200/cdn.example.com/img?id=5",
+			url:    "https://cdn.example.com/img?id=5",
+		},
+		{
+			name:   "encoded query",
+			target: "/fill/300/200/cdn.example.com/img?q=a%26b&tag=%D1%84",
+			url:    "https://cdn.example.com/img?q=a%26b&tag=%D1%84",
+		},
+		{
+			// Параметры запроса добавляются к уже имеющимся и ставятся перед фрагментом
+			name:   "encoded url with query",
+			target: "/fill/300/200/https%3A%2F%2Fcdn.example.com%2Fimg%3Fa%3D1%23top?b=2",
+			url:    "https://cdn.example.com/img?a=1&b=2#top",
+		},
+		{
+			name:   "default scheme",
+			scheme: "http",
+			target: "/fill/300/200/cdn.example.com/img?id=5",
+			url:    "http://cdn.example.com/img?id=5",
+		},
+		{
+			name:   "port",
+			target: "/fill/300/200/cdn.example.com:8443/a.jpg",
+			url:    "https://cdn.example.com:8443/a.jpg",
+		},
+		{
+			name:   "port with scheme",
+			target: "/fill/300/200/http:/127.0.0.1:8080/a.jpg",
+			url:    "http://127.0.0.1:8080/a.jpg",
+		},
+		{
+			name:   "ipv6",
+			target: "/fill/300/200/[2001:db8::1]/a.jpg",
+			url:    "https://[2001:db8::1]/a.jpg",
+		},
+		{
+			name:   "ipv6 with port",
+			target: "/fill/300/200/http:/[2001:db8::1]:8080/a.jpg?id=5",
+			url:    "http://[2001:db8::1]:8080/a.jpg?id=5",
+		},
+		{
+			// Закодированные символы пути передаются источнику без изменений
+			name:   "encoded path",
+			target: "/fill/300/200/cdn.example.com/img/a%20b%2Fc%3Fd.jpg",
+			url:    "https://cdn.example.com/img/a%20b%2Fc%3Fd.jpg",
+		},
+		{
+			name:   "alias with query",
+			target: "/fill/300/200/@assets/a%20b.jpg?v=2",
+			url:    "https://cdn.example.com/static/a%20b.jpg?v=2",
+		},
+		{
+			// Для локальных файлов параметры запроса не имеют смысла
+			name:   "local",
+			target: "/fill/300/200/local/assets/a%20b.jpg?v=2",
+			url:    "file://assets/a%20b.jpg",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServiceWithOrigins(t, config.OriginsConf{
+				DefaultScheme: tc.scheme,
+				Aliases:       map[string]string{"assets": "https://cdn.example.com/static"},
+			})
+
+			params, err := prepareFromPath(t, s, tc.target)
+			require.NoError(t, err)
+			require.Equal(t, tc.url, params.URL)
+		})
+	}
+}
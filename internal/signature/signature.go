@@ -0,0 +1,119 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+)
+
+var (
+	ErrSignatureRequired = errors.New("preview url signature is required")
+	ErrInvalidSignature  = errors.New("invalid preview url signature")
+	ErrURLExpired        = errors.New("preview url has expired")
+)
+
+// Signer подписывает и проверяет адреса превью. Подпись - HMAC-SHA256 от пути с параметрами
+// обработки и адресом изображения, закодированный в URL-safe base64 без выравнивания.
+type Signer struct {
+	keys      [][]byte
+	clockSkew time.Duration
+	now       func() time.Time
+}
+
+// NewSigner создает Signer с ключами из конфигурации. Без ключей подпись не проверяется.
+func NewSigner(conf config.SigningConf) *Signer {
+	s := &Signer{
+		keys:      make([][]byte, 0, len(conf.Keys)),
+		clockSkew: conf.ClockSkew,
+		now:       time.Now,
+	}
+	for _, key := range conf.Keys {
+		s.keys = append(s.keys, []byte(key))
+	}
+	return s
+}
+
+// Enabled сообщает, требуется ли подпись адресов.
+func (s *Signer) Enabled() bool {
+	return len(s.keys) > 0
+}
+
+// Sign возвращает подпись пути первым ключом. Путь передается так, как он будет отправлен
+// сервису: закодированным и вместе с параметрами запроса, например /fill/300/200/cdn.example.com/a.jpg?v=2.
+func (s *Signer) Sign(path string) string {
+	if !s.Enabled() {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(sign(s.keys[0], path))
+}
+
+// Verify проверяет подпись пути. Подходит подпись любым из ключей, что позволяет
+// заменять ключи, не отзывая уже выданные адреса.
+func (s *Signer) Verify(signature, path string) error {
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	for _, key := range s.keys {
+		if hmac.Equal(mac, sign(key, path)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// CleanPath приводит путь к виду, в котором его принимает маршрутизатор сервиса: повторные
+// "/", "." и ".." в пути без параметров запроса убираются так же, как это делает gorilla/mux.
+// На путь, отличающийся от очищенного, маршрутизатор отвечает редиректом, и подпись
+// исходного пути к нему не подходит.
+func CleanPath(p string) string {
+	p, query, hasQuery := strings.Cut(p, "?")
+	if p == "" {
+		p = "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+
+	cleaned := path.Clean(p)
+	if p[len(p)-1] == '/' && cleaned != "/" {
+		cleaned += "/"
+	}
+	if hasQuery {
+		cleaned += "?" + query
+	}
+	return cleaned
+}
+
+// ExpiringPath добавляет к пути срок действия: /exp/{unix}/fill/... Срок входит в подписываемый
+// путь, поэтому подписывать нужно уже результат.
+func ExpiringPath(path string, expires time.Time) string {
+	return "/exp/" + strconv.FormatInt(expires.Unix(), 10) + path
+}
+
+// CheckExpiry проверяет срок действия адреса, заданный в секундах Unix, с учетом расхождения часов.
+func (s *Signer) CheckExpiry(expires string) error {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if s.now().After(time.Unix(unix, 0).Add(s.clockSkew)) {
+		return ErrURLExpired
+	}
+	return nil
+}
+
+func sign(key []byte, path string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,91 @@
+package signature
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+const signedPath = "/fill/300/200/https%3A%2F%2Fcdn.example.com%2Fa.jpg?v=2"
+
+func TestSignVerify(t *testing.T) {
+	signer := NewSigner(config.SigningConf{Keys: []string{"current-key-0123456789"}})
+	require.True(t, signer.Enabled())
+
+	sig := signer.Sign(signedPath)
+	require.NotEmpty(t, sig)
+	require.NoError(t, signer.Verify(sig, signedPath))
+
+	// Подпись не подходит к измененным параметрам, адресу или параметрам запроса
+	for _, tampered := range []string{
+		"/fill/3000/2000/https%3A%2F%2Fcdn.example.com%2Fa.jpg?v=2",
+		"/fill/300/200/https%3A%2F%2Fevil.example.com%2Fa.jpg?v=2",
+		"/fill/300/200/https%3A%2F%2Fcdn.example.com%2Fa.jpg",
+	} {
+		require.ErrorIs(t, signer.Verify(sig, tampered), ErrInvalidSignature, tampered)
+	}
+
+	require.ErrorIs(t, signer.Verify("not*base64", signedPath), ErrInvalidSignature)
+	require.ErrorIs(t, signer.Verify("", signedPath), ErrInvalidSignature)
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldSigner := NewSigner(config.SigningConf{Keys: []string{"old-key-0123456789"}})
+	signer := NewSigner(config.SigningConf{Keys: []string{"new-key-0123456789", "old-key-0123456789"}})
+
+	// Новые адреса подписываются первым ключом, выданные ранее продолжают работать
+	require.NotEqual(t, oldSigner.Sign(signedPath), signer.Sign(signedPath))
+	require.NoError(t, signer.Verify(signer.Sign(signedPath), signedPath))
+	require.NoError(t, signer.Verify(oldSigner.Sign(signedPath), signedPath))
+
+	// После удаления ключа подписанные им адреса перестают работать
+	newSigner := NewSigner(config.SigningConf{Keys: []string{"new-key-0123456789"}})
+	require.ErrorIs(t, newSigner.Verify(oldSigner.Sign(signedPath), signedPath), ErrInvalidSignature)
+}
+
+func TestSignerDisabled(t *testing.T) {
+	signer := NewSigner(config.SigningConf{})
+	require.False(t, signer.Enabled())
+	require.Empty(t, signer.Sign(signedPath))
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := map[string]string{
+		"/fill/300/200/https://cdn.example.com/a.jpg":         "/fill/300/200/https:/cdn.example.com/a.jpg",
+		"/fill/300/200/cdn.example.com/img/../a.jpg?v=2//3":   "/fill/300/200/cdn.example.com/a.jpg?v=2//3",
+		"/fill/300/200/cdn.example.com/img/./a.jpg/":          "/fill/300/200/cdn.example.com/img/a.jpg/",
+		"/fill/300/200/https%3A%2F%2Fcdn.example.com%2Fa.jpg": "/fill/300/200/https%3A%2F%2Fcdn.example.com%2Fa.jpg",
+	}
+
+	for p, cleaned := range tests {
+		require.Equal(t, cleaned, CleanPath(p), p)
+	}
+}
+
+func TestExpiringPath(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := NewSigner(config.SigningConf{Keys: []string{"current-key-0123456789"}, ClockSkew: 30 * time.Second})
+	signer.now = func() time.Time { return now }
+
+	expiring := ExpiringPath(signedPath, now.Add(time.Hour))
+	require.Equal(t, "/exp/1700003600"+signedPath, expiring)
+
+	// Срок входит в подпись, продлить адрес без ключа нельзя
+	sig := signer.Sign(expiring)
+	require.NoError(t, signer.Verify(sig, expiring))
+	require.ErrorIs(t, signer.Verify(sig, ExpiringPath(signedPath, now.Add(24*time.Hour))), ErrInvalidSignature)
+}
+
+func TestCheckExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := NewSigner(config.SigningConf{ClockSkew: 30 * time.Second})
+	signer.now = func() time.Time { return now }
+
+	require.NoError(t, signer.CheckExpiry("1700000060"))
+	// Истекший недавно адрес еще принимается с учетом расхождения часов
+	require.NoError(t, signer.CheckExpiry("1699999980"))
+	require.ErrorIs(t, signer.CheckExpiry("1699999960"), ErrURLExpired)
+	require.ErrorIs(t, signer.CheckExpiry("soon"), ErrInvalidSignature)
+}
@@ -0,0 +1,214 @@
+package boltstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	imagesBucket   = []byte("images")
+	metadataBucket = []byte("metadata")
+)
+
+// BoltStorage хранит превью и метаданные во встроенной базе ключ-значение в одном файле.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{imagesBucket, metadataBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create bolt buckets: %w", err)
+	}
+
+	return &BoltStorage{db: db}, nil
+}
+
+func (b *BoltStorage) Set(id string, meta storage.Metadata) (storage.Writer, error) {
+	return &boltWriter{storage: b, id: id, meta: meta}, nil
+}
+
+// Get читает превью в транзакции только для чтения. Время последнего обращения не обновляется:
+// запись на каждое чтение сериализовала бы чтения и требовала fsync, обращения записывает Touch.
+func (b *BoltStorage) Get(id string) (io.ReadCloser, storage.Metadata, error) {
+	var (
+		data []byte
+		meta storage.Metadata
+	)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(imagesBucket).Get([]byte(id))
+		if value == nil {
+			return storage.ErrNotFound
+		}
+		// Значения действительны только внутри транзакции, поэтому копируем их
+		data = bytes.Clone(value)
+
+		metaValue := tx.Bucket(metadataBucket).Get([]byte(id))
+		if metaValue == nil {
+			return nil
+		}
+		if err := json.Unmarshal(metaValue, &meta); err != nil {
+			return fmt.Errorf("failed to parse metadata: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, storage.Metadata{}, err
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), meta, nil
+}
+
+func (b *BoltStorage) GetMetadata(id string) (storage.Metadata, error) {
+	var meta storage.Metadata
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(metadataBucket).Get([]byte(id))
+		if value == nil {
+			return storage.ErrMetadataNotFound
+		}
+		if err := json.Unmarshal(value, &meta); err != nil {
+			return fmt.Errorf("failed to parse metadata: %w", err)
+		}
+		return nil
+	})
+
+	return meta, err
+}
+
+func (b *BoltStorage) UpdateMetadata(id string, meta storage.Metadata) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(imagesBucket).Get([]byte(id)) == nil {
+			return storage.ErrNotFound
+		}
+
+		meta.LastAccessAt = time.Now()
+		meta.CreatedAt = meta.LastAccessAt
+		if value := tx.Bucket(metadataBucket).Get([]byte(id)); value != nil {
+			var old storage.Metadata
+			if err := json.Unmarshal(value, &old); err != nil {
+				return fmt.Errorf("failed to parse metadata: %w", err)
+			}
+			meta.CreatedAt = old.CreatedAt
+		}
+
+		return putMetadata(tx, id, meta)
+	})
+}
+
+// Touch выполняется через db.Batch: обращения из разных горутин объединяются в одну транзакцию.
+func (b *BoltStorage) Touch(id string) error {
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		if tx.Bucket(imagesBucket).Get([]byte(id)) == nil {
+			return storage.ErrNotFound
+		}
+
+		var meta storage.Metadata
+		if value := tx.Bucket(metadataBucket).Get([]byte(id)); value != nil {
+			if err := json.Unmarshal(value, &meta); err != nil {
+				return fmt.Errorf("failed to parse metadata: %w", err)
+			}
+		}
+		meta.LastAccessAt = time.Now()
+		if meta.CreatedAt.IsZero() {
+			meta.CreatedAt = meta.LastAccessAt
+		}
+		return putMetadata(tx, id, meta)
+	})
+}
+
+func (b *BoltStorage) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(imagesBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(metadataBucket).Delete([]byte(id))
+	})
+}
+
+func (b *BoltStorage) List() ([]string, error) {
+	var ids []string
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).ForEach(func(k, _ []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+
+	return ids, err
+}
+
+// Close закрывает файл базы данных.
+func (b *BoltStorage) Close() error {
+	return b.db.Close()
+}
+
+func putMetadata(tx *bolt.Tx, id string, meta storage.Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(metadataBucket).Put([]byte(id), data)
+}
+
+// boltWriter накапливает данные и записывает превью с метаданными в одной транзакции.
+type boltWriter struct {
+	storage *BoltStorage
+	id      string
+	meta    storage.Metadata
+	buf     bytes.Buffer
+	aborted bool
+}
+
+func (w *boltWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *boltWriter) Abort() error {
+	w.aborted = true
+	w.buf.Reset()
+	return nil
+}
+
+func (w *boltWriter) Close() error {
+	if w.aborted {
+		return nil
+	}
+	now := time.Now()
+	w.meta.CreatedAt = now
+	w.meta.LastAccessAt = now
+
+	return w.storage.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(imagesBucket).Put([]byte(w.id), w.buf.Bytes()); err != nil {
+			return err
+		}
+		return putMetadata(tx, w.id, w.meta)
+	})
+}
@@ -0,0 +1,19 @@
+package boltstorage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Lanworm/image-previewer/internal/storage"
+	"github.com/Lanworm/image-previewer/internal/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := NewBoltStorage(filepath.Join(t.TempDir(), "previews.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
@@ -0,0 +1,196 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Регистрация декодеров поддерживаемых форматов для проверки изображений
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/logger"
+)
+
+const defaultGCInterval = time.Minute
+
+// Quota ограничения хранилища на диске, нулевое значение означает отсутствие ограничения.
+type Quota struct {
+	MaxSize  int64 // Максимальный суммарный размер в байтах
+	MaxFiles int   // Максимальное количество изображений
+}
+
+// entry изображение в хранилище вместе с файлом метаданных.
+type entry struct {
+	id         string
+	size       int64
+	lastAccess time.Time
+}
+
+func (f *FileStorage) SetQuota(quota Quota) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.quota = quota
+}
+
+// StartGC периодически запускает сборку мусора до отмены контекста.
+func (f *FileStorage) StartGC(ctx context.Context, interval time.Duration, logg *logger.Logger) {
+	if interval <= 0 {
+		interval = defaultGCInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := f.CollectGarbage()
+			if err != nil {
+				logg.Error("storage gc: " + err.Error())
+				continue
+			}
+			if removed > 0 {
+				logg.Debug(fmt.Sprintf("storage gc: removed %d images", removed))
+			}
+		}
+	}
+}
+
+// CollectGarbage удаляет давно не запрашиваемые изображения, пока хранилище превышает квоту.
+func (f *FileStorage) CollectGarbage() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.quota.MaxSize <= 0 && f.quota.MaxFiles <= 0 {
+		return 0, nil
+	}
+
+	entries, err := f.entries()
+	if err != nil {
+		return 0, err
+	}
+
+	var totalSize int64
+	for _, e := range entries {
+		totalSize += e.size
+	}
+
+	// Сначала удаляем изображения, к которым дольше всего не обращались
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastAccess.Before(entries[j].lastAccess)
+	})
+
+	removed := 0
+	for _, e := range entries {
+		if !f.overQuota(totalSize, len(entries)-removed) {
+			break
+		}
+		if err := f.delete(e.id); err != nil {
+			return removed, err
+		}
+		totalSize -= e.size
+		removed++
+	}
+
+	return removed, nil
+}
+
+// Scan очищает хранилище при старте: удаляет оставшиеся временные файлы,
+// метаданные без изображений и файлы, которые не удается декодировать.
+func (f *FileStorage) Scan() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.MkdirAll(f.storagePath, os.ModePerm); err != nil {
+		return err
+	}
+
+	dirEntries, err := os.ReadDir(f.storagePath)
+	if err != nil {
+		return err
+	}
+
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() {
+			continue
+		}
+		name := dirEntry.Name()
+		path := filepath.Join(f.storagePath, name)
+
+		switch {
+		case strings.HasSuffix(name, tempSuffix):
+			err = os.Remove(path)
+		case strings.HasSuffix(name, metaSuffix):
+			_, statErr := os.Stat(strings.TrimSuffix(path, metaSuffix))
+			if errors.Is(statErr, os.ErrNotExist) {
+				err = os.Remove(path)
+			}
+		default:
+			if !isDecodable(path) {
+				err = f.delete(name)
+			}
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to clean up %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *FileStorage) overQuota(totalSize int64, files int) bool {
+	if f.quota.MaxSize > 0 && totalSize > f.quota.MaxSize {
+		return true
+	}
+	return f.quota.MaxFiles > 0 && files > f.quota.MaxFiles
+}
+
+// entries собирает размеры и время последнего обращения для всех изображений хранилища.
+func (f *FileStorage) entries() ([]entry, error) {
+	ids, err := f.List()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entry, 0, len(ids))
+	for _, id := range ids {
+		info, err := os.Stat(filepath.Join(f.storagePath, id))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		e := entry{id: id, size: info.Size(), lastAccess: info.ModTime()}
+		if metaInfo, err := os.Stat(filepath.Join(f.storagePath, id+metaSuffix)); err == nil {
+			e.size += metaInfo.Size()
+		}
+		if meta, err := f.GetMetadata(id); err == nil {
+			e.lastAccess = meta.LastAccessAt
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+func isDecodable(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	_, _, err = image.DecodeConfig(file)
+	return err == nil
+}
@@ -0,0 +1,57 @@
+package filestorage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFileStorage(dir)
+	fs.SetQuota(Quota{MaxFiles: 2})
+
+	for _, id := range []string{"first", "second", "third"} {
+		writeImage(t, fs, id, storage.Metadata{})
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Обращение к первому изображению делает второе самым давним
+	r, _, err := fs.Get("first")
+	require.NoError(t, err)
+	r.Close()
+
+	removed, err := fs.CollectGarbage()
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+
+	files, err := fs.List()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"first", "third"}, files)
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFileStorage(dir)
+
+	writeImage(t, fs, "valid", storage.Metadata{})
+
+	// Недописанный временный файл, битое изображение и метаданные без изображения
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lost.123"+tempSuffix), []byte("tmp"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken"), []byte("not an image"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "orphan"+metaSuffix), []byte("{}"), 0o600))
+
+	require.NoError(t, fs.Scan())
+
+	dirEntries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := make([]string, 0, len(dirEntries))
+	for _, e := range dirEntries {
+		names = append(names, e.Name())
+	}
+	require.ElementsMatch(t, []string{"valid", "valid" + metaSuffix}, names)
+}
@@ -1,72 +1,167 @@
 package filestorage
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"image"
-	"image/jpeg"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/storage"
+)
+
+const (
+	metaSuffix = ".meta.json"
+	tempSuffix = ".tmp"
 )
 
 type FileStorage struct {
 	storagePath string
+	quota       Quota
+	mu          sync.Mutex
 }
 
 func NewFileStorage(path string) *FileStorage {
 	return &FileStorage{storagePath: path}
 }
 
-func (f FileStorage) Set(item image.Image, id string) error {
+func (f *FileStorage) Set(id string, meta storage.Metadata) (storage.Writer, error) {
 	if err := os.MkdirAll(f.storagePath, os.ModePerm); err != nil {
-		return err
+		return nil, err
 	}
 
-	outputFile, err := os.Create(filepath.Join(f.storagePath, id))
+	// Данные пишутся во временный файл и переименовываются только при закрытии
+	tmpFile, err := os.CreateTemp(f.storagePath, id+".*"+tempSuffix)
 	if err != nil {
+		return nil, err
+	}
+
+	return &fileWriter{storage: f, id: id, meta: meta, file: tmpFile}, nil
+}
+
+func (f *FileStorage) Get(id string) (io.ReadCloser, storage.Metadata, error) {
+	file, err := os.Open(filepath.Join(f.storagePath, id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, storage.Metadata{}, storage.ErrNotFound
+		}
+		return nil, storage.Metadata{}, err
+	}
+
+	meta, err := f.GetMetadata(id)
+	if err != nil && !errors.Is(err, storage.ErrMetadataNotFound) {
+		file.Close()
+		return nil, storage.Metadata{}, err
+	}
+
+	// Обновляем время последнего обращения, отсутствие метаданных ошибкой не считаем
+	if err := f.Touch(id); err != nil {
+		file.Close()
+		return nil, storage.Metadata{}, err
+	}
+
+	return file, meta, nil
+}
+
+func (f *FileStorage) Touch(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(f.storagePath, id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return storage.ErrNotFound
+		}
 		return err
 	}
 
-	defer outputFile.Close()
-	err = jpeg.Encode(outputFile, item, nil)
+	meta, err := f.GetMetadata(id)
+	if errors.Is(err, storage.ErrMetadataNotFound) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	return nil
+	meta.LastAccessAt = time.Now()
+
+	return f.setMetadata(id, meta)
 }
 
-func (f FileStorage) Get(id string) (image.Image, error) {
-	file, err := os.Open(filepath.Join(f.storagePath, id))
+func (f *FileStorage) GetMetadata(id string) (storage.Metadata, error) {
+	var meta storage.Metadata
+
+	data, err := os.ReadFile(filepath.Join(f.storagePath, id+metaSuffix))
 	if err != nil {
-		return nil, err
+		if errors.Is(err, os.ErrNotExist) {
+			return meta, storage.ErrMetadataNotFound
+		}
+		return meta, err
 	}
-	defer file.Close()
 
-	img, _, err := image.Decode(file)
-	if err != nil {
-		return nil, err
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+	return meta, nil
+}
+
+func (f *FileStorage) UpdateMetadata(id string, meta storage.Metadata) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(f.storagePath, id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return storage.ErrNotFound
+		}
+		return err
+	}
+
+	old, err := f.GetMetadata(id)
+	if err != nil && !errors.Is(err, storage.ErrMetadataNotFound) {
+		return err
+	}
+	meta.CreatedAt = old.CreatedAt
+	meta.LastAccessAt = time.Now()
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = meta.LastAccessAt
 	}
-	return img, nil
+
+	return f.setMetadata(id, meta)
 }
 
-func (f FileStorage) Delete(id string) error {
+func (f *FileStorage) Delete(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.delete(id)
+}
+
+func (f *FileStorage) delete(id string) error {
 	err := os.Remove(filepath.Join(f.storagePath, id))
-	if err != nil {
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
+
+	err = os.Remove(filepath.Join(f.storagePath, id+metaSuffix))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete metadata: %w", err)
+	}
 	return nil
 }
 
-func (f FileStorage) GetFileList(folderPath string) ([]string, error) {
+func (f *FileStorage) List() ([]string, error) {
 	// Проверяем существование папки, если нет - создаем
-	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
-		err := os.MkdirAll(folderPath, 0o755)
+	if _, err := os.Stat(f.storagePath); os.IsNotExist(err) {
+		err := os.MkdirAll(f.storagePath, 0o755)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	// Читаем содержимое папки
-	fileInfos, err := os.ReadDir(folderPath)
+	fileInfos, err := os.ReadDir(f.storagePath)
 	if err != nil {
 		return nil, err
 	}
@@ -76,10 +171,113 @@ func (f FileStorage) GetFileList(folderPath string) ([]string, error) {
 
 	// Проходим по всем элементам в папке
 	for _, fileInfo := range fileInfos {
-		if !fileInfo.IsDir() { // Проверяем, не является ли текущий элемент папкой
-			filenames = append(filenames, fileInfo.Name())
+		if fileInfo.IsDir() { // Проверяем, не является ли текущий элемент папкой
+			continue
 		}
+		// Пропускаем файлы метаданных и недописанные временные файлы
+		if isServiceFile(fileInfo.Name()) {
+			continue
+		}
+		filenames = append(filenames, fileInfo.Name())
 	}
 
 	return filenames, nil
 }
+
+func (f *FileStorage) setMetadata(id string, meta storage.Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return f.writeAtomic(id+metaSuffix, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeAtomic записывает файл через временный файл и переименование,
+// чтобы при сбое в хранилище не оставалось недописанных файлов.
+func (f *FileStorage) writeAtomic(name string, write func(w io.Writer) error) error {
+	tmpFile, err := os.CreateTemp(f.storagePath, name+".*"+tempSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if err := write(tmpFile); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filepath.Join(f.storagePath, name))
+}
+
+// fileWriter записывает превью во временный файл и публикует его при закрытии.
+type fileWriter struct {
+	storage *FileStorage
+	id      string
+	meta    storage.Metadata
+	file    *os.File
+	err     error
+	aborted bool
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err := w.file.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *fileWriter) Abort() error {
+	if w.aborted {
+		return nil
+	}
+	w.aborted = true
+
+	w.file.Close()
+	if err := os.Remove(w.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (w *fileWriter) Close() error {
+	if w.aborted {
+		return nil
+	}
+	defer os.Remove(w.file.Name())
+
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	w.storage.mu.Lock()
+	defer w.storage.mu.Unlock()
+
+	if err := os.Rename(w.file.Name(), filepath.Join(w.storage.storagePath, w.id)); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w.meta.CreatedAt = now
+	w.meta.LastAccessAt = now
+
+	return w.storage.setMetadata(w.id, w.meta)
+}
+
+func isServiceFile(name string) bool {
+	return strings.HasSuffix(name, metaSuffix) || strings.HasSuffix(name, tempSuffix)
+}
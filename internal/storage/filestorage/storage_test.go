@@ -0,0 +1,79 @@
+package filestorage
+
+import (
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Lanworm/image-previewer/internal/storage"
+	"github.com/Lanworm/image-previewer/internal/storage/storagetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return NewFileStorage(t.TempDir())
+	})
+}
+
+func TestFileStorageMetadata(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFileStorage(dir)
+
+	writeImage(t, fs, "preview", storage.Metadata{
+		SourceURL:    "http://example.com/image.jpg",
+		Width:        10,
+		Height:       10,
+		ContentType:  "image/jpeg",
+		UpstreamETag: `"abc"`,
+	})
+
+	// Метаданные сохраняются рядом с изображением и дополняются хранилищем
+	meta, err := fs.GetMetadata("preview")
+	require.NoError(t, err)
+	require.Equal(t, "http://example.com/image.jpg", meta.SourceURL)
+	require.Equal(t, `"abc"`, meta.UpstreamETag)
+	require.Equal(t, "image/jpeg", meta.ContentType)
+	require.False(t, meta.CreatedAt.IsZero())
+
+	// Файлы метаданных не попадают в список изображений
+	files, err := fs.List()
+	require.NoError(t, err)
+	require.Equal(t, []string{"preview"}, files)
+
+	// Удаление убирает и изображение, и метаданные
+	require.NoError(t, fs.Delete("preview"))
+	_, err = fs.GetMetadata("preview")
+	require.ErrorIs(t, err, storage.ErrMetadataNotFound)
+	_, err = os.Stat(filepath.Join(dir, "preview"+metaSuffix))
+	require.True(t, os.IsNotExist(err))
+}
+
+// Запись не публикуется, если при записи данных произошла ошибка.
+func TestFileStorageWriteFailure(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFileStorage(dir)
+
+	w, err := fs.Set("preview", storage.Metadata{})
+	require.NoError(t, err)
+	w.(*fileWriter).err = os.ErrClosed
+	require.ErrorIs(t, w.Close(), os.ErrClosed)
+
+	_, _, err = fs.Get("preview")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	dirEntries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, dirEntries)
+}
+
+func writeImage(t *testing.T, fs *FileStorage, id string, meta storage.Metadata) {
+	t.Helper()
+
+	w, err := fs.Set(id, meta)
+	require.NoError(t, err)
+	require.NoError(t, jpeg.Encode(w, image.NewRGBA(image.Rect(0, 0, 10, 10)), nil))
+	require.NoError(t, w.Close())
+}
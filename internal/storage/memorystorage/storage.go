@@ -0,0 +1,138 @@
+package memorystorage
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/storage"
+)
+
+type item struct {
+	data []byte
+	meta storage.Metadata
+}
+
+// MemoryStorage хранит превью в памяти процесса, подходит для тестов и небольших инсталляций.
+type MemoryStorage struct {
+	items map[string]item
+	mu    sync.RWMutex
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{items: make(map[string]item)}
+}
+
+func (m *MemoryStorage) Set(id string, meta storage.Metadata) (storage.Writer, error) {
+	return &memoryWriter{storage: m, id: id, meta: meta}, nil
+}
+
+func (m *MemoryStorage) Get(id string) (io.ReadCloser, storage.Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, ok := m.items[id]
+	if !ok {
+		return nil, storage.Metadata{}, storage.ErrNotFound
+	}
+	meta := it.meta
+	it.meta.LastAccessAt = time.Now()
+	m.items[id] = it
+
+	return io.NopCloser(bytes.NewReader(it.data)), meta, nil
+}
+
+func (m *MemoryStorage) GetMetadata(id string) (storage.Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	it, ok := m.items[id]
+	if !ok {
+		return storage.Metadata{}, storage.ErrMetadataNotFound
+	}
+	return it.meta, nil
+}
+
+func (m *MemoryStorage) UpdateMetadata(id string, meta storage.Metadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, ok := m.items[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	meta.CreatedAt = it.meta.CreatedAt
+	meta.LastAccessAt = time.Now()
+	it.meta = meta
+	m.items[id] = it
+	return nil
+}
+
+func (m *MemoryStorage) Touch(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, ok := m.items[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+	it.meta.LastAccessAt = time.Now()
+	m.items[id] = it
+	return nil
+}
+
+func (m *MemoryStorage) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.items, id)
+	return nil
+}
+
+func (m *MemoryStorage) List() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.items))
+	for id := range m.items {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// memoryWriter накапливает данные и публикует превью при закрытии.
+type memoryWriter struct {
+	storage *MemoryStorage
+	id      string
+	meta    storage.Metadata
+	buf     bytes.Buffer
+	aborted bool
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memoryWriter) Abort() error {
+	w.aborted = true
+	w.buf.Reset()
+	return nil
+}
+
+func (w *memoryWriter) Close() error {
+	if w.aborted {
+		return nil
+	}
+	now := time.Now()
+	w.meta.CreatedAt = now
+	w.meta.LastAccessAt = now
+
+	w.storage.mu.Lock()
+	defer w.storage.mu.Unlock()
+
+	w.storage.items[w.id] = item{data: w.buf.Bytes(), meta: w.meta}
+	return nil
+}
@@ -0,0 +1,14 @@
+package memorystorage
+
+import (
+	"testing"
+
+	"github.com/Lanworm/image-previewer/internal/storage"
+	"github.com/Lanworm/image-previewer/internal/storage/storagetest"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(_ *testing.T) storage.Storage {
+		return NewMemoryStorage()
+	})
+}
@@ -0,0 +1,209 @@
+package s3storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/config"
+	"github.com/Lanworm/image-previewer/internal/storage"
+)
+
+const (
+	metaSuffix     = ".meta.json"
+	defaultTimeout = 10 * time.Second
+)
+
+// S3Storage хранит превью в бакете S3-совместимого хранилища,
+// что позволяет нескольким репликам сервиса использовать общие изображения.
+// Метаданные хранятся отдельным объектом рядом с изображением.
+type S3Storage struct {
+	client *s3Client
+	prefix string
+}
+
+func NewS3Storage(conf config.S3Conf) (*S3Storage, error) {
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", conf.Endpoint)
+	}
+
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	region := conf.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3Storage{
+		client: &s3Client{
+			endpoint:  endpoint,
+			region:    region,
+			bucket:    conf.Bucket,
+			accessKey: conf.AccessKey,
+			secretKey: conf.SecretKey,
+			pathStyle: conf.PathStyle,
+			http:      &http.Client{Timeout: timeout},
+			now:       time.Now,
+		},
+		prefix: conf.Prefix,
+	}, nil
+}
+
+func (s *S3Storage) Set(id string, meta storage.Metadata) (storage.Writer, error) {
+	return &objectWriter{storage: s, id: id, meta: meta}, nil
+}
+
+func (s *S3Storage) Get(id string) (io.ReadCloser, storage.Metadata, error) {
+	meta, err := s.GetMetadata(id)
+	if err != nil && !errors.Is(err, storage.ErrMetadataNotFound) {
+		return nil, storage.Metadata{}, err
+	}
+
+	body, err := s.client.openObject(context.Background(), s.key(id))
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return nil, storage.Metadata{}, storage.ErrNotFound
+		}
+		return nil, storage.Metadata{}, err
+	}
+	return body, meta, nil
+}
+
+func (s *S3Storage) GetMetadata(id string) (storage.Metadata, error) {
+	var meta storage.Metadata
+
+	data, err := s.client.getObject(context.Background(), s.key(id)+metaSuffix)
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			return meta, storage.ErrMetadataNotFound
+		}
+		return meta, err
+	}
+
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+	return meta, nil
+}
+
+func (s *S3Storage) UpdateMetadata(id string, meta storage.Metadata) error {
+	ctx := context.Background()
+
+	old, err := s.GetMetadata(id)
+	switch {
+	case errors.Is(err, storage.ErrMetadataNotFound):
+		// Метаданных нет, проверяем, существует ли само изображение
+		if err := s.client.headObject(ctx, s.key(id)); err != nil {
+			if errors.Is(err, errObjectNotFound) {
+				return storage.ErrNotFound
+			}
+			return err
+		}
+		old.CreatedAt = time.Now()
+	case err != nil:
+		return err
+	}
+
+	meta.CreatedAt = old.CreatedAt
+	meta.LastAccessAt = time.Now()
+	return s.setMetadata(ctx, id, meta)
+}
+
+func (s *S3Storage) Touch(id string) error {
+	meta, err := s.GetMetadata(id)
+	if err != nil && !errors.Is(err, storage.ErrMetadataNotFound) {
+		return err
+	}
+	return s.UpdateMetadata(id, meta)
+}
+
+func (s *S3Storage) Delete(id string) error {
+	ctx := context.Background()
+	if err := s.client.deleteObject(ctx, s.key(id)); err != nil && !errors.Is(err, errObjectNotFound) {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	if err := s.client.deleteObject(ctx, s.key(id)+metaSuffix); err != nil && !errors.Is(err, errObjectNotFound) {
+		return fmt.Errorf("failed to delete metadata: %w", err)
+	}
+	return nil
+}
+
+// List возвращает идентификаторы изображений под настроенным префиксом.
+func (s *S3Storage) List() ([]string, error) {
+	keys, err := s.client.listObjects(context.Background(), s.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if strings.HasSuffix(key, metaSuffix) {
+			continue
+		}
+		ids = append(ids, strings.TrimPrefix(key, s.prefix))
+	}
+	return ids, nil
+}
+
+func (s *S3Storage) setMetadata(ctx context.Context, id string, meta storage.Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	if err := s.client.putObject(ctx, s.key(id)+metaSuffix, data, "application/json"); err != nil {
+		return fmt.Errorf("failed to upload metadata: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) key(id string) string {
+	return s.prefix + id
+}
+
+// objectWriter накапливает превью в памяти и загружает его в бакет при закрытии,
+// так как для загрузки объекта нужен его полный размер.
+type objectWriter struct {
+	storage *S3Storage
+	id      string
+	meta    storage.Metadata
+	buf     bytes.Buffer
+	aborted bool
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *objectWriter) Abort() error {
+	w.aborted = true
+	w.buf.Reset()
+	return nil
+}
+
+func (w *objectWriter) Close() error {
+	if w.aborted {
+		return nil
+	}
+	now := time.Now()
+	w.meta.CreatedAt = now
+	w.meta.LastAccessAt = now
+
+	ctx := context.Background()
+	err := w.storage.client.putObject(ctx, w.storage.key(w.id), w.buf.Bytes(), w.meta.ContentType)
+	if err != nil {
+		return fmt.Errorf("failed to upload image: %w", err)
+	}
+
+	return w.storage.setMetadata(ctx, w.id, w.meta)
+}
@@ -1,12 +1,57 @@
 package storage
 
 import (
-	"image"
+	"errors"
+	"io"
+	"time"
 )
 
+var (
+	ErrNotFound         = errors.New("item not found in storage")
+	ErrMetadataNotFound = errors.New("metadata not found")
+)
+
+// Metadata описывает сохраненное превью: откуда оно получено и с какими параметрами.
+type Metadata struct {
+	SourceURL    string    `json:"sourceUrl"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	ContentType  string    `json:"contentType"`
+	ETag         string    `json:"etag"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastAccessAt time.Time `json:"lastAccessAt"`
+
+	// Валидаторы исходного изображения, полученные от удаленного сервера
+	UpstreamETag         string `json:"upstreamEtag,omitempty"`
+	UpstreamLastModified string `json:"upstreamLastModified,omitempty"`
+	// ValidatedAt время последней загрузки или подтверждения актуальности у удаленного сервера
+	ValidatedAt time.Time `json:"validatedAt"`
+}
+
+// Writer запись превью в хранилище.
+type Writer interface {
+	io.WriteCloser
+	// Abort отменяет запись: записанные данные отбрасываются, ранее сохраненное превью
+	// с тем же идентификатором не меняется. Close после Abort ничего не сохраняет.
+	Abort() error
+}
+
+// Storage хранит закодированные превью в том виде, в котором они были отданы клиенту.
 type Storage interface {
-	Set(item image.Image, id string) error
-	Get(id string) (image.Image, error)
+	// Set открывает запись превью. Данные и метаданные становятся доступны только
+	// после успешного Close. Если данные не удалось сформировать целиком, запись
+	// нужно отменить через Abort, иначе Close сохранит то, что успело записаться.
+	Set(id string, meta Metadata) (Writer, error)
+	// Get возвращает содержимое превью и его метаданные, ErrNotFound если превью нет.
+	// Время последнего обращения Get обновлять не обязан, для этого есть Touch.
+	Get(id string) (io.ReadCloser, Metadata, error)
+	GetMetadata(id string) (Metadata, error)
+	// UpdateMetadata заменяет метаданные существующего превью, не перезаписывая его данные.
+	// Время создания сохраняется, ErrNotFound если превью нет.
+	UpdateMetadata(id string, meta Metadata) error
+	// Touch обновляет время последнего обращения к превью, ErrNotFound если превью нет.
+	Touch(id string) error
+	// Delete удаляет превью, удаление отсутствующего превью ошибкой не считается.
 	Delete(id string) error
-	GetFileList(folderPath string) ([]string, error)
+	List() ([]string, error)
 }
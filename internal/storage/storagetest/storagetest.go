@@ -0,0 +1,284 @@
+// Package storagetest содержит общий набор тестов, которому должна соответствовать
+// любая реализация storage.Storage.
+package storagetest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Lanworm/image-previewer/internal/storage"
+)
+
+// Run запускает набор тестов для хранилища, newStorage должна возвращать пустое хранилище.
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	t.Helper()
+
+	t.Run("set and get", func(t *testing.T) {
+		s := newStorage(t)
+		meta := storage.Metadata{
+			SourceURL:            "http://example.com/image.png",
+			Width:                300,
+			Height:               200,
+			ContentType:          "image/png",
+			ETag:                 `"etag"`,
+			UpstreamETag:         `"upstream"`,
+			UpstreamLastModified: "Mon, 02 Jan 2006 15:04:05 GMT",
+			ValidatedAt:          time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+		put(t, s, "preview", []byte("preview data"), meta)
+
+		data, got := get(t, s, "preview")
+		if !bytes.Equal(data, []byte("preview data")) {
+			t.Fatalf("unexpected data: %q", data)
+		}
+		checkMetadata(t, meta, got)
+
+		stored, err := s.GetMetadata("preview")
+		if err != nil {
+			t.Fatalf("get metadata: %v", err)
+		}
+		checkMetadata(t, meta, stored)
+	})
+
+	t.Run("get missing", func(t *testing.T) {
+		s := newStorage(t)
+
+		if _, _, err := s.Get("missing"); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+		if _, err := s.GetMetadata("missing"); !errors.Is(err, storage.ErrMetadataNotFound) {
+			t.Fatalf("expected ErrMetadataNotFound, got %v", err)
+		}
+	})
+
+	t.Run("not visible before close", func(t *testing.T) {
+		s := newStorage(t)
+
+		w, err := s.Set("preview", storage.Metadata{})
+		if err != nil {
+			t.Fatalf("set: %v", err)
+		}
+		if _, err := w.Write([]byte("partial")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, _, err := s.Get("preview"); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound before close, got %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		if data, _ := get(t, s, "preview"); string(data) != "partial" {
+			t.Fatalf("unexpected data: %q", data)
+		}
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		s := newStorage(t)
+
+		w, err := s.Set("preview", storage.Metadata{})
+		if err != nil {
+			t.Fatalf("set: %v", err)
+		}
+		if _, err := w.Write([]byte("partial")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := w.Abort(); err != nil {
+			t.Fatalf("abort: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("close after abort: %v", err)
+		}
+		if _, _, err := s.Get("preview"); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound after abort, got %v", err)
+		}
+		checkList(t, s, nil)
+
+		// Отмененная перезапись не затрагивает сохраненное превью
+		put(t, s, "preview", []byte("stored"), storage.Metadata{ContentType: "image/png"})
+		w, err = s.Set("preview", storage.Metadata{ContentType: "image/jpeg"})
+		if err != nil {
+			t.Fatalf("set: %v", err)
+		}
+		if _, err := w.Write([]byte("partial")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := w.Abort(); err != nil {
+			t.Fatalf("abort: %v", err)
+		}
+		if data, meta := get(t, s, "preview"); string(data) != "stored" || meta.ContentType != "image/png" {
+			t.Fatalf("expected stored item, got %q %q", data, meta.ContentType)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		s := newStorage(t)
+		put(t, s, "preview", []byte("old"), storage.Metadata{ContentType: "image/jpeg"})
+		put(t, s, "preview", []byte("new"), storage.Metadata{ContentType: "image/png"})
+
+		data, meta := get(t, s, "preview")
+		if string(data) != "new" || meta.ContentType != "image/png" {
+			t.Fatalf("expected overwritten item, got %q %q", data, meta.ContentType)
+		}
+	})
+
+	t.Run("update metadata", func(t *testing.T) {
+		s := newStorage(t)
+		put(t, s, "preview", []byte("data"), storage.Metadata{UpstreamETag: `"old"`})
+		created, err := s.GetMetadata("preview")
+		if err != nil {
+			t.Fatalf("get metadata: %v", err)
+		}
+
+		meta := storage.Metadata{UpstreamETag: `"new"`, ValidatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+		if err := s.UpdateMetadata("preview", meta); err != nil {
+			t.Fatalf("update metadata: %v", err)
+		}
+
+		data, got := get(t, s, "preview")
+		if string(data) != "data" {
+			t.Fatalf("data must not change, got %q", data)
+		}
+		checkMetadata(t, meta, got)
+		if !got.CreatedAt.Equal(created.CreatedAt) {
+			t.Fatalf("creation time must be kept, got %v, expected %v", got.CreatedAt, created.CreatedAt)
+		}
+
+		if err := s.UpdateMetadata("missing", meta); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("touch", func(t *testing.T) {
+		s := newStorage(t)
+		meta := storage.Metadata{ContentType: "image/png", UpstreamETag: `"upstream"`}
+		put(t, s, "preview", []byte("data"), meta)
+		before, err := s.GetMetadata("preview")
+		if err != nil {
+			t.Fatalf("get metadata: %v", err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		if err := s.Touch("preview"); err != nil {
+			t.Fatalf("touch: %v", err)
+		}
+
+		after, err := s.GetMetadata("preview")
+		if err != nil {
+			t.Fatalf("get metadata: %v", err)
+		}
+		checkMetadata(t, meta, after)
+		if !after.LastAccessAt.After(before.LastAccessAt) {
+			t.Fatalf("access time must move forward, got %v, was %v", after.LastAccessAt, before.LastAccessAt)
+		}
+		if !after.CreatedAt.Equal(before.CreatedAt) {
+			t.Fatalf("creation time must be kept, got %v, expected %v", after.CreatedAt, before.CreatedAt)
+		}
+
+		if err := s.Touch("missing"); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("list and delete", func(t *testing.T) {
+		s := newStorage(t)
+		put(t, s, "first", []byte("1"), storage.Metadata{})
+		put(t, s, "second", []byte("2"), storage.Metadata{})
+
+		checkList(t, s, []string{"first", "second"})
+
+		if err := s.Delete("first"); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if _, _, err := s.Get("first"); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound after delete, got %v", err)
+		}
+		if _, err := s.GetMetadata("first"); !errors.Is(err, storage.ErrMetadataNotFound) {
+			t.Fatalf("expected ErrMetadataNotFound after delete, got %v", err)
+		}
+		checkList(t, s, []string{"second"})
+	})
+
+	// Удаление отсутствующего превью не ошибка во всех хранилищах, даже если хранилище
+	// сообщает об отсутствии объекта
+	t.Run("delete missing", func(t *testing.T) {
+		s := newStorage(t)
+		put(t, s, "first", []byte("1"), storage.Metadata{})
+
+		if err := s.Delete("missing"); err != nil {
+			t.Fatalf("delete missing: %v", err)
+		}
+		if err := s.Delete("first"); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if err := s.Delete("first"); err != nil {
+			t.Fatalf("delete twice: %v", err)
+		}
+		checkList(t, s, nil)
+	})
+}
+
+func put(t *testing.T, s storage.Storage, id string, data []byte, meta storage.Metadata) {
+	t.Helper()
+
+	w, err := s.Set(id, meta)
+	if err != nil {
+		t.Fatalf("set %s: %v", id, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("write %s: %v", id, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close %s: %v", id, err)
+	}
+}
+
+func get(t *testing.T, s storage.Storage, id string) ([]byte, storage.Metadata) {
+	t.Helper()
+
+	r, meta, err := s.Get(id)
+	if err != nil {
+		t.Fatalf("get %s: %v", id, err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read %s: %v", id, err)
+	}
+	return data, meta
+}
+
+func checkMetadata(t *testing.T, expected, actual storage.Metadata) {
+	t.Helper()
+
+	if actual.CreatedAt.IsZero() || actual.LastAccessAt.IsZero() {
+		t.Fatalf("storage must set creation and access time, got %+v", actual)
+	}
+	// Время проставляет хранилище, остальные поля должны сохраниться без изменений
+	actual.CreatedAt, actual.LastAccessAt = expected.CreatedAt, expected.LastAccessAt
+	if expected != actual {
+		t.Fatalf("metadata mismatch:\nexpected %+v\nactual   %+v", expected, actual)
+	}
+}
+
+func checkList(t *testing.T, s storage.Storage, expected []string) {
+	t.Helper()
+
+	ids, err := s.List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	sort.Strings(ids)
+	if len(ids) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, ids)
+		}
+	}
+}